@@ -11,6 +11,7 @@ export default function OAuthCallback() {
     const handleOAuthCallback = async () => {
       const urlParams = new URLSearchParams(window.location.search);
       const code = urlParams.get('code');
+      const state = urlParams.get('state');
       const error = urlParams.get('error');
 
       if (error) {
@@ -25,11 +26,17 @@ export default function OAuthCallback() {
         return;
       }
 
+      if (!state) {
+        console.error('No OAuth state received');
+        router.push('/?error=invalid_state');
+        return;
+      }
+
       try {
         console.log('🔄 Exchanging authorization code for tokens...');
         
         // Exchange code for tokens using Firebase Functions
-        const tokens = await GoogleCalendarOAuthService.exchangeCodeForTokens(code);
+        const tokens = await GoogleCalendarOAuthService.exchangeCodeForTokens(code, state);
         
         console.log('✅ Calendar access tokens received');
         
@@ -92,9 +92,9 @@ export default function CalendarSync({ onSyncComplete }: CalendarSyncProps) {
               Connect your Google Calendar to sync your events and get personalized recommendations.
             </p>
             <button
-              onClick={() => {
+              onClick={async () => {
                 try {
-                  GoogleCalendarOAuthService.requestCalendarAccess();
+                  await GoogleCalendarOAuthService.requestCalendarAccess();
                 } catch (error) {
                   console.error('Error requesting calendar access:', error);
                   setError('Failed to connect to Google Calendar');
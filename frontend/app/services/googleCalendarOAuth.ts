@@ -15,12 +15,18 @@ export class GoogleCalendarOAuthService {
   /**
    * Simple OAuth flow - just redirect to Google
    */
-  static requestCalendarAccess(): void {
+  static async requestCalendarAccess(): Promise<void> {
     if (typeof window === 'undefined') {
       console.error('Cannot request calendar access on server side');
       return;
     }
 
+    // The backend issues a single-use state value and checks it again at code exchange (CSRF protection)
+    const { functions } = await import('@/lib/firebase');
+    const { httpsCallable } = await import('firebase/functions');
+    const oauthState = httpsCallable(functions, 'oauthState');
+    const { state } = (await oauthState()).data as { success: boolean; state: string };
+
     console.log('🚀 Starting simple Google OAuth flow...');
     
     const redirectUri = this.getRedirectUri();
@@ -34,7 +40,8 @@ export class GoogleCalendarOAuthService {
       scope: 'https://www.googleapis.com/auth/calendar.readonly',
       response_type: 'code',
       access_type: 'offline',
-      prompt: 'consent'
+      prompt: 'consent',
+      state
     });
 
     const authUrl = `https://accounts.google.com/o/oauth2/v2/auth?${params.toString()}`;
@@ -116,14 +123,14 @@ export class GoogleCalendarOAuthService {
   /**
    * Exchange OAuth code for tokens using Firebase Functions
    */
-  static async exchangeCodeForTokens(code: string): Promise<{ access_token: string; refresh_token?: string }> {
+  static async exchangeCodeForTokens(code: string, state: string): Promise<{ access_token: string; refresh_token?: string }> {
     try {
-      // Use Firebase Functions SDK instead of direct HTTP calls
+      // Callable, so the signed-in user's ID token goes along and the backend can match it to the state
       const { functions } = await import('@/lib/firebase');
       const { httpsCallable } = await import('firebase/functions');
       
       const oauthExchange = httpsCallable(functions, 'oauthExchange');
-      const result = await oauthExchange({ code });
+      const result = await oauthExchange({ code, state, redirect_uri: this.getRedirectUri() });
       
       const data = result.data as { 
         success: boolean; 
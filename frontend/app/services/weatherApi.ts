@@ -56,6 +56,8 @@ export interface ForecastDay {
   dayName: string;
   highTemp: number;
   lowTemp: number;
+  feelsLikeHigh: number;
+  feelsLikeLow: number;
   condition: string;
   icon: string;
   humidity: number;
@@ -155,7 +157,7 @@ export class WeatherApiService {
         windSpeed: weatherData.windSpeed,
         windDirection: weatherData.windDirection || 'N/A', // Use actual wind direction from API
         pressure: weatherData.pressure || 0, // Atmospheric pressure in hPa
-        uvIndex: 0, // Not provided by basic API
+        uvIndex: weatherData.uvIndex ?? 0, // Null when the UV lookup failed
         feelsLike: weatherData.temperature, // Approximation
         timestamp: weatherData.timestamp,
       };
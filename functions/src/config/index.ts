@@ -9,16 +9,186 @@ import {getAuth} from "firebase-admin/auth";
 export const weatherApiKey = defineSecret("weather_api_key");
 export const googleClientId = defineSecret("google_client_id");
 export const googleClientSecret = defineSecret("google_client_secret");
+export const tokenEncryptionKey = defineSecret("token_encryption_key"); // base64, 32 bytes (AES-256)
 
 // Initialize Firebase Admin
 initializeApp();
 export const db = getFirestore();
 export const auth = getAuth();
 
-// Cache configuration
+// Cache configuration - each TTL can be overridden per deployment (milliseconds)
 export const CACHE_TTL = {
-  CURRENT_WEATHER: 1 * 60 * 1000, // 1 minute (temporarily reduced)
-  FORECAST: 1 * 60 * 1000, // 1 minute (temporarily reduced)
-  LOCATION: 60 * 60 * 1000, // 1 hour (location rarely changes)
-  FIRESTORE_CACHE: 30 * 60 * 1000, // 30 minutes
+  CURRENT_WEATHER: Number(process.env.CACHE_TTL_CURRENT_WEATHER_MS) || 1 * 60 * 1000, // 1 minute (temporarily reduced)
+  FORECAST: Number(process.env.CACHE_TTL_FORECAST_MS) || 1 * 60 * 1000, // 1 minute (temporarily reduced)
+  AIR_QUALITY: Number(process.env.CACHE_TTL_AIR_QUALITY_MS) || 30 * 60 * 1000, // 30 minutes (the provider updates hourly)
+  GEOCODE: Number(process.env.CACHE_TTL_GEOCODE_MS) || 24 * 60 * 60 * 1000, // 24 hours (place names and coordinates are stable)
+  FIRESTORE_CACHE: Number(process.env.CACHE_TTL_FIRESTORE_MS) || 30 * 60 * 1000, // 30 minutes
+  // Calendar events are cached per instance only - never in Firestore, they're the user's private data
+  CALENDAR_EVENTS: Number(process.env.CACHE_TTL_CALENDAR_EVENTS_MS) || 1 * 60 * 1000, // 1 minute
+};
+
+// Geocoding cache - reverse lookups are keyed on rounded coordinates so nearby points share one entry
+export const GEOCODE_CONFIG = {
+  CACHE_PRECISION: Number(process.env.GEOCODE_CACHE_PRECISION ?? 2), // Decimal places; 2 is ~1.1km, well inside a town
+};
+
+// Prefix for every cache key (e.g. "swx:prod:") so environments sharing a project don't collide
+export const CACHE_NAMESPACE = process.env.CACHE_NAMESPACE || "";
+
+// Outbound HTTP configuration
+export const HTTP_CONFIG = {
+  CA_BUNDLE_PATH: process.env.CA_BUNDLE_PATH || "", // PEM bundle appended to the system roots (TLS inspection proxies)
+  // Largest upstream response body we'll buffer; calendar feeds get their own, larger cap
+  MAX_RESPONSE_BYTES: Number(process.env.HTTP_MAX_RESPONSE_BYTES) || 1024 * 1024,
+  MAX_ICS_RESPONSE_BYTES: Number(process.env.HTTP_MAX_ICS_RESPONSE_BYTES) || 10 * 1024 * 1024,
+  // Per-attempt timeout for clients that don't set their own, so a stalled upstream can't hold a request open
+  TIMEOUT_MS: Number(process.env.HTTP_TIMEOUT_MS) || 15 * 1000,
+  // Retries for idempotent requests that failed on the network or with a 5xx (never 4xx/429)
+  MAX_RETRIES: Number(process.env.HTTP_MAX_RETRIES ?? 2),
+  RETRY_BASE_DELAY_MS: Number(process.env.HTTP_RETRY_BASE_DELAY_MS) || 200,
+  USER_AGENT: process.env.HTTP_USER_AGENT || "ScottWeatherService/1.0",
+};
+
+// CORS configuration - comma-separated origins, or "*" for any origin
+const CORS_ALLOWED_ORIGINS = (process.env.CORS_ALLOWED_ORIGINS || "*")
+  .split(",")
+  .map((origin) => origin.trim())
+  .filter(Boolean);
+
+export const CORS_CONFIG = {
+  ALLOWED_ORIGINS: CORS_ALLOWED_ORIGINS,
+  ALLOWED_HEADERS: "Content-Type, Authorization, X-Request-ID",
+  EXPOSED_HEADERS: "X-Request-ID", // So browser clients can quote the ID when reporting a failure
+  // The same allowlist in the form onCall's cors option takes
+  CALLABLE_CORS: CORS_ALLOWED_ORIGINS.includes("*") ? true : CORS_ALLOWED_ORIGINS,
+};
+
+// OAuth redirect URIs - the calendar-connect default and the comma-separated list a client may ask the code
+// exchange to use. A default outside the list could never be used, so it fails here rather than at every exchange
+function loadOAuthRedirectUris(): { DEFAULT_REDIRECT_URI: string; ALLOWED_REDIRECT_URIS: string[] } {
+  const defaultUri = process.env.OAUTH_CALENDAR_REDIRECT_URI || (process.env.NODE_ENV === "development"
+    ? "http://localhost:3000/auth/callback/"
+    : "https://scott-weather-service.web.app/auth/callback/");
+  const allowedUris = (process.env.OAUTH_ALLOWED_REDIRECT_URIS ||
+    "http://localhost:3000/auth/callback/,https://scott-weather-service.web.app/auth/callback/")
+    .split(",")
+    .map((uri) => uri.trim())
+    .filter(Boolean);
+
+  if (!allowedUris.includes(defaultUri)) {
+    throw new Error(`OAuth redirect URI ${defaultUri} must be listed in OAUTH_ALLOWED_REDIRECT_URIS (${allowedUris.join(", ")})`);
+  }
+  return { DEFAULT_REDIRECT_URI: defaultUri, ALLOWED_REDIRECT_URIS: allowedUris };
+}
+
+// OAuth configuration
+export const OAUTH_CONFIG = {
+  // Redirects for the calendar-connect flow (sign-in goes through Firebase Auth and never reaches the code exchange)
+  ...loadOAuthRedirectUris(),
+  // How long an issued OAuth state value stays redeemable
+  STATE_TTL_MS: Number(process.env.OAUTH_STATE_TTL_MS) || 10 * 60 * 1000,
+};
+
+// Calendar token sweeper configuration
+export const TOKEN_SWEEPER_CONFIG = {
+  INTERVAL_MINUTES: Number(process.env.TOKEN_SWEEPER_INTERVAL_MINUTES) || 30,
+  BATCH_SIZE: Number(process.env.TOKEN_SWEEPER_BATCH_SIZE) || 50,
+  REFRESH_LEAD_MS: 15 * 60 * 1000, // Refresh tokens expiring within 15 minutes
+  ACCESS_TOKEN_LIFETIME_MS: 60 * 60 * 1000, // Google access tokens last 1 hour (used when expiry_date wasn't stored)
+};
+
+// Precipitation probability cutoffs (percent) for "slight chance" / "chance" / "likely"
+export function loadPrecipitationThresholds() {
+  const thresholds = {
+    LOW: Number(process.env.PRECIP_THRESHOLD_LOW ?? 10),
+    MEDIUM: Number(process.env.PRECIP_THRESHOLD_MEDIUM ?? 30),
+    HIGH: Number(process.env.PRECIP_THRESHOLD_HIGH ?? 60),
+  };
+
+  const values = [thresholds.LOW, thresholds.MEDIUM, thresholds.HIGH];
+  if (values.some((value) => !Number.isFinite(value) || value < 0 || value > 100)) {
+    throw new Error(`Precipitation thresholds must be between 0 and 100, got ${values.join("/")}`);
+  }
+  if (!(thresholds.LOW < thresholds.MEDIUM && thresholds.MEDIUM < thresholds.HIGH)) {
+    throw new Error(`Precipitation thresholds must be ascending (low < medium < high), got ${values.join("/")}`);
+  }
+
+  return thresholds;
+}
+
+export const PRECIPITATION_THRESHOLDS = loadPrecipitationThresholds();
+
+// Calendar configuration
+export const CALENDAR_CONFIG = {
+  DEFAULT_MAX_RESULTS: Number(process.env.CALENDAR_DEFAULT_MAX_RESULTS) || 10,
+  MAX_RESULTS_CAP: Number(process.env.CALENDAR_MAX_RESULTS_CAP) || 250, // Google's own per-page maximum is 2500
+  GOOGLE_TIMEOUT_MS: Number(process.env.CALENDAR_GOOGLE_TIMEOUT_MS) || 10 * 1000, // Per Google Calendar API call
+  MICROSOFT_TIMEOUT_MS: Number(process.env.CALENDAR_MICROSOFT_TIMEOUT_MS) || 10 * 1000, // Per Microsoft Graph call
+  // Longest range a keyword search may cover, and its default - broad scans are slow on large calendars
+  SEARCH_WINDOW_DAYS: Number(process.env.CALENDAR_SEARCH_WINDOW_DAYS) || 90,
+  MAX_SEARCH_QUERY_LENGTH: 200,
+  // Distinct event locations geocoded per weather-annotated listing; the rest use the default location
+  MAX_EVENT_GEOCODES: Number(process.env.CALENDAR_MAX_EVENT_GEOCODES) || 10,
+  // Total ICS feed bodies kept per instance for conditional requests; least recently used feeds go first
+  ICS_FEED_CACHE_BYTES: Number(process.env.CALENDAR_ICS_FEED_CACHE_BYTES) || 32 * 1024 * 1024,
+};
+
+export type WeatherApiVersion = "2.5" | "3.0";
+
+// OpenWeatherMap API version. WEATHER_API_PLAN is the older name for the same choice ("free" or "onecall"):
+// it's still accepted, but must agree with WEATHER_API_VERSION when both are set
+export function loadWeatherApiVersion(): WeatherApiVersion {
+  const version = process.env.WEATHER_API_VERSION;
+  if (version !== undefined && version !== "2.5" && version !== "3.0") {
+    throw new Error(`WEATHER_API_VERSION must be 2.5 or 3.0, got ${version}`);
+  }
+
+  const plan = process.env.WEATHER_API_PLAN;
+  if (plan !== undefined && plan !== "free" && plan !== "onecall") {
+    throw new Error(`WEATHER_API_PLAN must be free or onecall, got ${plan}`);
+  }
+  const planVersion: WeatherApiVersion | undefined = plan === undefined ? undefined : (plan === "onecall" ? "3.0" : "2.5");
+
+  if (version && planVersion && version !== planVersion) {
+    throw new Error(`WEATHER_API_PLAN=${plan} needs WEATHER_API_VERSION=${planVersion}, got ${version}`);
+  }
+  return version || planVersion || "2.5";
+}
+
+// Weather provider configuration
+export const WEATHER_CONFIG = {
+  BASE_URL: process.env.WEATHER_API_BASE_URL || "https://api.openweathermap.org/data/2.5",
+  // 5-day/3-hour forecasts have 40 entries; anything beyond the cap is ignored rather than processed
+  MAX_FORECAST_ITEMS: Number(process.env.WEATHER_MAX_FORECAST_ITEMS) || 40,
+  // Per-request timeout for OpenWeatherMap calls (weather, One Call, geocoding) so a hung provider can't hold a function open
+  TIMEOUT_MS: Number(process.env.WEATHER_API_TIMEOUT_MS) || 10 * 1000,
+  // Batch current-weather lookups: most locations per call, and how many are fetched at once
+  MAX_BATCH_SIZE: Number(process.env.WEATHER_MAX_BATCH_SIZE) || 20,
+  BATCH_CONCURRENCY: Number(process.env.WEATHER_BATCH_CONCURRENCY) || 4,
+  // "2.5" calls /weather and /forecast separately; "3.0" takes current, forecast, UV and alerts from one One Call
+  // request and needs a One Call 3.0 subscription. It also decides how many forecast days a client may ask for
+  API_VERSION: loadWeatherApiVersion(),
+  ONECALL_URL: process.env.WEATHER_ONECALL_URL || "https://api.openweathermap.org/data/3.0/onecall",
+};
+
+// Daily forecast length each API version can serve: 2.5's 5-day/3-hour forecast, or One Call's 8 daily entries
+export const FORECAST_DAYS_BY_API_VERSION: { [version in WeatherApiVersion]: number } = {
+  "2.5": 5,
+  "3.0": 8,
+};
+
+// Saved locations - each user's list is read whole inside every create/update transaction, so it stays small
+export const SAVED_LOCATIONS_CONFIG = {
+  MAX_PER_USER: Number(process.env.SAVED_LOCATIONS_MAX_PER_USER) || 25,
+};
+
+// Location used when a request has no coordinates and the user has no default saved location
+export const FALLBACK_LOCATION = {
+  LATITUDE: Number(process.env.FALLBACK_LATITUDE ?? 37.7749), // San Francisco city center unless overridden per deployment
+  LONGITUDE: Number(process.env.FALLBACK_LONGITUDE ?? -122.4194),
+};
+
+// Request logging - "json" emits one structured entry per request, "text" a single readable line (local emulators)
+export const LOG_CONFIG = {
+  FORMAT: process.env.LOG_FORMAT === "text" ? "text" : "json",
 };
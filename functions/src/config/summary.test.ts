@@ -0,0 +1,41 @@
+// Configuration summary tests - what the cold-start log line would carry, checked on the summary it logs
+
+import { afterEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { getConfigSummary, redactConfig } from "./summary";
+
+const SECRET_ENV_NAMES = ["WEATHER_API_KEY", "TOKEN_ENCRYPTION_KEY"];
+const savedEnv = SECRET_ENV_NAMES.map((name) => process.env[name]);
+
+afterEach(() => {
+  SECRET_ENV_NAMES.forEach((name, i) => {
+    if (savedEnv[i] === undefined) delete process.env[name]; else process.env[name] = savedEnv[i];
+  });
+});
+
+test("credential-looking entries are redacted at any depth, arrays included", () => {
+  assert.deepEqual(redactConfig({
+    apiKey: "abc123",
+    nested: { clientSecret: "s3cret", refresh_token: "rt-1", Password: 42, timeoutMs: 5000 },
+    providers: [{ name: "google", credentials: "json-blob" }],
+  }), {
+    apiKey: "[redacted]",
+    nested: { clientSecret: "[redacted]", refresh_token: "[redacted]", Password: "[redacted]", timeoutMs: 5000 },
+    providers: [{ name: "google", credentials: "[redacted]" }],
+  });
+});
+
+test("objects under credential-looking names are walked rather than dropped", () => {
+  assert.deepEqual(redactConfig({ tokenSweeper: { INTERVAL_MINUTES: 30, signingKey: "k" } }),
+    { tokenSweeper: { INTERVAL_MINUTES: 30, signingKey: "[redacted]" } });
+});
+
+test("env secret fallbacks are reported as set or unset, never by value", () => {
+  process.env.WEATHER_API_KEY = "owm-live-7f3c9a";
+  delete process.env.TOKEN_ENCRYPTION_KEY;
+
+  const summary = getConfigSummary() as { envSecretFallbacks: object; tokenSweeper: object; weather: { API_VERSION: string } };
+  assert.deepEqual(summary.envSecretFallbacks, { WEATHER_API_KEY: "set", TOKEN_ENCRYPTION_KEY: "unset" });
+  assert.equal(JSON.stringify(summary).includes("owm-live-7f3c9a"), false);
+  assert.ok(summary.tokenSweeper && summary.weather.API_VERSION, "ordinary settings stay visible");
+});
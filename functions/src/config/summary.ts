@@ -0,0 +1,64 @@
+// Effective configuration summary, logged once per cold start so overrides and defaults are visible
+
+import * as logger from "firebase-functions/logger";
+import {
+  CACHE_TTL, GEOCODE_CONFIG, CACHE_NAMESPACE, HTTP_CONFIG, CORS_CONFIG, OAUTH_CONFIG, TOKEN_SWEEPER_CONFIG,
+  PRECIPITATION_THRESHOLDS, CALENDAR_CONFIG, WEATHER_CONFIG, FORECAST_DAYS_BY_API_VERSION, SAVED_LOCATIONS_CONFIG,
+  FALLBACK_LOCATION, LOG_CONFIG,
+} from "./index";
+
+// Anything whose name looks like a credential is replaced, wherever it sits in the tree
+const SECRET_NAME_PATTERN = /key|secret|token|password|credential/i;
+const REDACTED = "[redacted]";
+
+// Helper function to copy a config tree with credential-looking entries redacted
+export function redactConfig(value: unknown): unknown {
+  if (Array.isArray(value)) {
+    return value.map(redactConfig);
+  }
+  if (value && typeof value === "object") {
+    const redacted: { [name: string]: unknown } = {};
+    Object.keys(value).forEach((name) => {
+      const entry = (value as { [name: string]: unknown })[name];
+      redacted[name] = SECRET_NAME_PATTERN.test(name) && typeof entry !== "object" ? REDACTED : redactConfig(entry);
+    });
+    return redacted;
+  }
+  return value;
+}
+
+// Build the summary - Secret Manager values are never read here, only whether a local env fallback is set
+export function getConfigSummary(): object {
+  const summary = redactConfig({
+    cacheTtlMs: CACHE_TTL,
+    cacheNamespace: CACHE_NAMESPACE,
+    geocode: GEOCODE_CONFIG,
+    http: HTTP_CONFIG,
+    cors: { allowedOrigins: CORS_CONFIG.ALLOWED_ORIGINS },
+    oauth: OAUTH_CONFIG,
+    tokenSweeper: TOKEN_SWEEPER_CONFIG,
+    precipitationThresholds: PRECIPITATION_THRESHOLDS,
+    calendar: CALENDAR_CONFIG,
+    weather: { ...WEATHER_CONFIG, forecastDays: FORECAST_DAYS_BY_API_VERSION[WEATHER_CONFIG.API_VERSION] },
+    savedLocations: SAVED_LOCATIONS_CONFIG,
+    fallbackLocation: FALLBACK_LOCATION,
+    log: LOG_CONFIG,
+  }) as object;
+
+  // Added after redaction - these report presence only, and their names would otherwise be redacted themselves
+  return {
+    ...summary,
+    envSecretFallbacks: {
+      WEATHER_API_KEY: process.env.WEATHER_API_KEY ? "set" : "unset",
+      TOKEN_ENCRYPTION_KEY: process.env.TOKEN_ENCRYPTION_KEY ? "set" : "unset",
+    },
+  };
+}
+
+// Log the summary once; skipped while the Firebase CLI loads the code to discover functions at deploy time
+export function logConfigSummary(): void {
+  if (process.env.FUNCTIONS_CONTROL_API === "true") {
+    return;
+  }
+  logger.info("Effective configuration", { config: getConfigSummary() });
+}
@@ -2,24 +2,32 @@
 // Clean, modular structure
 
 import { onRequest } from "firebase-functions/v2/https";
-import { onCall } from "firebase-functions/v2/https";
+import { onCall, HttpsError } from "firebase-functions/v2/https";
+import { onSchedule } from "firebase-functions/v2/scheduler";
 import { setGlobalOptions } from "firebase-functions";
-import { google } from "googleapis";
 import * as logger from "firebase-functions/logger";
 
 // Import configuration
-import { weatherApiKey, googleClientId, googleClientSecret, auth } from "./config";
+import { weatherApiKey, googleClientId, googleClientSecret, tokenEncryptionKey, auth, CACHE_TTL, TOKEN_SWEEPER_CONFIG, CALENDAR_CONFIG, CORS_CONFIG } from "./config";
+import { logConfigSummary } from "./config/summary";
 
 // Import types
-import { CalendarRequest, CalendarEventsRequest } from "./types";
+import { CalendarRequest, CalendarEventsRequest, CalendarSearchRequest, EventWeatherRequest, ForecastDay, NextEventRequest } from "./types";
 
 // Import modules
-import { getCalendarEventsWithToken, getCalendarEventsWithAuth, checkCalendarAccess, storeCalendarTokens, clearCalendarTokens } from "./modules/calendar";
-import { getCurrentWeather, getWeatherForecast } from "./modules/weather";
+import { getCalendarEventsWithToken, getCalendarEventsWithAuth, invalidateCalendarEventCache, checkCalendarAccess, storeCalendarTokens, clearCalendarTokens, resolveRedirectUri, createOAuthState, consumeOAuthState, normalizeIcsUrl, buildWeatherAnnotatedIcs, sweepExpiringCalendarTokens, getNextCalendarEvent, createGoogleOAuthClient, hasGoogleCalendarScopes, searchCalendarEvents, getCalendarEventsWithWeather } from "./modules/calendar";
+import { getCurrentWeather, getWeatherForecast, getWeatherSummary, resolveWeatherView, toMinimalWeatherResponse, getCurrentWeatherBatch, getAirQuality } from "./modules/weather";
+import { getIntegrationsStatus } from "./modules/integrations";
+import { deleteUserAccount } from "./modules/account";
+import { listSavedLocations, createSavedLocation, updateSavedLocation, deleteSavedLocation, resolveSavedLocation, resolveRequestLocation } from "./modules/locations";
+import { sendMethodNotAllowed, handleCors, handleUnknownPath, validateUserUrl, getCacheStats, normalizeUnits, authenticateRequest, authenticateCallable, authenticateAdminCallable, resolveCallableUser, allowRefreshForUser, runWithRequestContext, resolveUserUnits, validateCoordinates, deleteCachedWeatherData, getLocationCacheKeys, getCacheKey, getForecastCacheKey } from "./modules/shared";
 
 // Set global options for cost control
 setGlobalOptions({ maxInstances: 10 });
 
+// One structured line per cold start with the configuration that actually took effect (secrets redacted)
+logConfigSummary();
+
 // ============================================================================
 // CALENDAR FUNCTIONS
 // ============================================================================
@@ -28,79 +36,97 @@ setGlobalOptions({ maxInstances: 10 });
  * Calendar Function - Retrieves events from Google Calendar (legacy)
  */
 export const getCalendarEvents = onCall<CalendarRequest>(
-  { cors: true },
-  async (request) => {
-    return await getCalendarEventsWithToken(request.data);
-  }
+  { cors: CORS_CONFIG.CALLABLE_CORS },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    // Unauthenticated, so the caller's token is only ever sent to Google - ICS would make this an open feed fetcher
+    return await getCalendarEventsWithToken({ ...request.data, provider: "google" });
+  })
 );
 
 /**
  * Get calendar events with automatic token retrieval
  */
 export const getCalendarEventsWithAuthFunction = onCall<CalendarEventsRequest>(
-  { cors: true },
-  async (request) => {
-    const userId = request.auth?.uid;
-    if (!userId) {
-      throw new Error("User must be authenticated");
-    }
+  { cors: CORS_CONFIG.CALLABLE_CORS, secrets: [googleClientId, googleClientSecret, tokenEncryptionKey] },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await authenticateCallable(request);
     return await getCalendarEventsWithAuth(userId, request.data);
-  }
+  })
 );
 
 /**
- * OAuth token exchange endpoint
+ * Upcoming events with the forecast at each event's location and start time
  */
-export const oauthExchange = onRequest(
-  { secrets: [googleClientId, googleClientSecret] },
-  async (request, response) => {
-    // Set CORS headers
-    response.set("Access-Control-Allow-Origin", "*");
-    response.set("Access-Control-Allow-Methods", "GET, POST, OPTIONS");
-    response.set("Access-Control-Allow-Headers", "Content-Type, Authorization");
-
-    if (request.method === "OPTIONS") {
-      response.status(204).send("");
-      return;
+export const getCalendarEventsWithWeatherFunction = onCall<EventWeatherRequest>(
+  { cors: CORS_CONFIG.CALLABLE_CORS, secrets: [weatherApiKey, googleClientId, googleClientSecret, tokenEncryptionKey] },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await authenticateCallable(request);
+    return await getCalendarEventsWithWeather(userId, request.data);
+  })
+);
+
+/**
+ * Search the signed-in user's calendar events by keyword
+ */
+export const searchCalendarEventsFunction = onCall<CalendarSearchRequest>(
+  { cors: CORS_CONFIG.CALLABLE_CORS, secrets: [googleClientId, googleClientSecret, tokenEncryptionKey] },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await authenticateCallable(request);
+    return await searchCalendarEvents(userId, request.data);
+  })
+);
+
+/**
+ * OAuth token exchange - callable so the signed-in user comes from the verified ID token
+ */
+export const oauthExchange = onCall<{ code?: string; state?: string; redirect_uri?: string }>(
+  { cors: CORS_CONFIG.CALLABLE_CORS, secrets: [googleClientId, googleClientSecret] },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await authenticateCallable(request);
+
+    const { code, state, redirect_uri: requestedRedirectUri } = request.data || {};
+    if (!code) {
+      throw new HttpsError("invalid-argument", "Authorization code required");
     }
 
-    if (request.method !== "POST") {
-      response.status(405).json({ success: false, error: "Method not allowed" });
-      return;
+    // The state must be one we issued to this user via oauthState and not yet redeemed (CSRF protection)
+    const stateResult = await consumeOAuthState(state, userId);
+    if (stateResult === "wrong_user") {
+      logger.warn(`❌ Rejected OAuth exchange by user ${userId} with a state issued to another user`);
+      throw new HttpsError("permission-denied", "OAuth state was issued to a different user");
+    }
+    if (stateResult !== "valid") {
+      logger.warn("❌ Rejected OAuth exchange with missing, unknown or expired state");
+      throw new HttpsError("invalid-argument", "Invalid or expired OAuth state");
     }
 
-    try {
-      const { code } = request.body;
-      
-      if (!code) {
-        response.status(400).json({ success: false, error: "Authorization code required" });
-        return;
-      }
+    // Use the client's redirect URI only if it is allowlisted, otherwise the environment default
+    const redirectUri = resolveRedirectUri(requestedRedirectUri);
+    if (!redirectUri) {
+      logger.warn("❌ Rejected OAuth exchange with non-allowlisted redirect URI:", requestedRedirectUri);
+      throw new HttpsError("invalid-argument", "redirect_uri is not allowed");
+    }
 
-      // Get redirect URI from environment or use default
-      const redirectUri = process.env.NODE_ENV === "development" 
-        ? "http://localhost:3000/auth/callback/"
-        : "https://scott-weather-service.web.app/auth/callback/";
+    logger.info("🔍 OAuth exchange using redirect URI:", redirectUri);
 
-      logger.info("🔍 OAuth exchange using redirect URI:", redirectUri);
-      
-      const oAuth2Client = new google.auth.OAuth2(
-        googleClientId.value(),
-        googleClientSecret.value(),
-        redirectUri
-      );
+    try {
+      const oAuth2Client = createGoogleOAuthClient(redirectUri);
 
       // Exchange code for tokens
       const { tokens } = await oAuth2Client.getToken(code);
-      
+
       if (!tokens.access_token) {
-        response.status(400).json({ success: false, error: "No access token received" });
-        return;
+        throw new HttpsError("failed-precondition", "No access token received");
+      }
+
+      if (!hasGoogleCalendarScopes(tokens.scope)) {
+        logger.warn("❌ OAuth exchange without calendar scope, granted:", tokens.scope);
+        throw new HttpsError("failed-precondition", "Calendar read access was not granted");
       }
 
       // Return the tokens - frontend will store them
-      response.json({ 
-        success: true, 
+      return {
+        success: true,
         tokens: {
           access_token: tokens.access_token,
           refresh_token: tokens.refresh_token,
@@ -108,54 +134,65 @@ export const oauthExchange = onRequest(
           token_type: tokens.token_type,
           expiry_date: tokens.expiry_date
         }
-      });
-
+      };
     } catch (error) {
+      if (error instanceof HttpsError) {
+        throw error;
+      }
       logger.error("Token exchange error:", error);
-      response.status(500).json({ 
-        success: false, 
-        error: error instanceof Error ? error.message : "Unknown error" 
-      });
+      throw new HttpsError("internal", error instanceof Error ? error.message : "Unknown error");
     }
-  }
+  })
+);
+
+/**
+ * OAuth state issuer - call before redirecting to Google and pass the value as the state parameter
+ */
+export const oauthState = onCall(
+  { cors: CORS_CONFIG.CALLABLE_CORS },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await authenticateCallable(request);
+    return { success: true, state: await createOAuthState(userId) };
+  })
 );
 
 /**
  * Calendar authentication endpoint
  */
-export const calendarAuth = onRequest(async (request, response) => {
-  // Set CORS headers
-  response.set("Access-Control-Allow-Origin", "*");
-  response.set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS");
-  response.set("Access-Control-Allow-Headers", "Content-Type, Authorization");
-
-  if (request.method === "OPTIONS") {
-    response.status(204).send("");
+export const calendarAuth = onRequest({ secrets: [tokenEncryptionKey] }, (request, response) => runWithRequestContext(request, async () => {
+  if (handleCors(request, response, ["GET", "POST", "DELETE", "OPTIONS"]) || handleUnknownPath(request, response)) {
     return;
   }
 
   try {
     logger.info("🔍 Calendar auth API called");
     
-    // Get the Firebase ID token from the Authorization header
-    const authHeader = request.headers.authorization;
-    if (!authHeader || !authHeader.startsWith("Bearer ")) {
-      logger.info("❌ No Firebase token provided in Authorization header");
-      response.status(401).json({ error: "No Firebase token provided" });
+    // Verify the Firebase ID token from the Authorization header
+    const userId = await authenticateRequest(request, response);
+    if (!userId) {
       return;
     }
-
-    const idToken = authHeader.replace("Bearer ", "");
-    logger.info("🔑 Firebase token received, verifying...");
-    
-    // Verify the Firebase ID token
-    const decodedToken = await auth.verifyIdToken(idToken);
-    const userId = decodedToken.uid;
     logger.info("✅ Firebase token verified for user:", userId);
     
     if (request.method === "POST") {
-      // Store Google Calendar token
-      const { googleToken } = request.body;
+      // Store the calendar token, recording which provider issued it
+      const { googleToken, provider = "google", icsUrl } = request.body;
+      
+      if (provider === "ics") {
+        // ICS feeds have no OAuth token - the feed URL is the credential
+        let feedUrl: string;
+        try {
+          feedUrl = await validateUserUrl(normalizeIcsUrl(icsUrl || ""));
+        } catch (error) {
+          logger.warn("❌ Rejected ICS URL:", error);
+          response.status(400).json({ success: false, error: "A valid, publicly reachable http(s) or webcal ICS URL is required" });
+          return;
+        }
+        
+        await storeCalendarTokens(userId, { access_token: feedUrl, scope: "ics" }, "ics");
+        response.json({ success: true, message: "ICS calendar feed stored successfully" });
+        return;
+      }
       
       if (!googleToken) {
         response.status(400).json({ 
@@ -165,7 +202,15 @@ export const calendarAuth = onRequest(async (request, response) => {
         return;
       }
       
-      await storeCalendarTokens(userId, googleToken);
+      if (provider !== "google" && provider !== "microsoft") {
+        response.status(400).json({
+          success: false,
+          error: `Unsupported calendar provider: ${provider}`
+        });
+        return;
+      }
+      
+      await storeCalendarTokens(userId, googleToken, provider);
       
       response.json({
         success: true,
@@ -181,7 +226,7 @@ export const calendarAuth = onRequest(async (request, response) => {
         message: "Google Calendar OAuth token cleared successfully"
       });
     } else {
-      response.status(405).json({ success: false, error: "Method not allowed" });
+      sendMethodNotAllowed(response, ["POST", "DELETE", "OPTIONS"]);
     }
     
   } catch (error) {
@@ -191,21 +236,137 @@ export const calendarAuth = onRequest(async (request, response) => {
       error: error instanceof Error ? error.message : "Unknown error" 
     });
   }
-});
+}));
+
+/**
+ * Calendar export endpoint - upcoming events as an ICS file, annotated with the forecast
+ */
+export const calendarExport = onRequest(
+  { secrets: [weatherApiKey, googleClientId, googleClientSecret, tokenEncryptionKey] },
+  (request, response) => runWithRequestContext(request, async () => {
+    if (handleCors(request, response, ["GET", "OPTIONS"]) || handleUnknownPath(request, response)) {
+      return;
+    }
+
+    if (request.method !== "GET") {
+      sendMethodNotAllowed(response, ["GET", "OPTIONS"]);
+      return;
+    }
+
+    try {
+      const userId = await authenticateRequest(request, response);
+      if (!userId) {
+        return;
+      }
+
+      // Events for the forecast window (5 days)
+      const now = new Date();
+      const { events } = await getCalendarEventsWithAuth(userId, {
+        timeMin: now.toISOString(),
+        timeMax: new Date(now.getTime() + 5 * 24 * 60 * 60 * 1000).toISOString(),
+        maxResults: CALENDAR_CONFIG.MAX_RESULTS_CAP,
+      });
+
+      // Weather annotations need a location; without one the events are exported as-is
+      const units = normalizeUnits(request.query.units);
+      let forecastDays: ForecastDay[] = [];
+      if (request.query.lat !== undefined && request.query.lon !== undefined) {
+        try {
+          const forecast = await getWeatherForecast({
+            latitude: Number(request.query.lat),
+            longitude: Number(request.query.lon),
+            units,
+          });
+          forecastDays = forecast.data.days;
+        } catch (error) {
+          logger.warn("Exporting calendar without weather annotations:", error);
+        }
+      }
+
+      response.set("Content-Type", "text/calendar; charset=utf-8");
+      response.set("Content-Disposition", "attachment; filename=\"weather-schedule.ics\"");
+      response.send(buildWeatherAnnotatedIcs(events, forecastDays, units));
+    } catch (error) {
+      logger.error("Calendar export error:", error);
+      // Typed calendar errors (e.g. reconnect required -> 401) keep their status and code
+      if (error instanceof HttpsError) {
+        response.status(error.httpErrorCode.status).json({ success: false, error: error.message, ...(error.details as object) });
+        return;
+      }
+      response.status(500).json({
+        success: false,
+        error: error instanceof Error ? error.message : "Unknown error"
+      });
+    }
+  })
+);
+
+/**
+ * Next upcoming event with weather (callable) - for "what's next" widgets
+ */
+export const getNextCalendarEventFunction = onCall<NextEventRequest>(
+  { cors: CORS_CONFIG.CALLABLE_CORS, secrets: [weatherApiKey, googleClientId, googleClientSecret, tokenEncryptionKey] },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await authenticateCallable(request);
+    return await getNextCalendarEvent(userId, request.data);
+  })
+);
 
 /**
  * Calendar status check function (callable)
  */
 export const calendarStatus = onCall(
-  { cors: true },
-  async (request) => {
-    const userId = request.auth?.uid;
-    if (!userId) {
-      throw new Error("User must be authenticated");
-    }
+  { cors: CORS_CONFIG.CALLABLE_CORS },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await authenticateCallable(request);
     
     const hasAccess = await checkCalendarAccess(userId);
     return { hasAccess };
+  })
+);
+
+/**
+ * Drop the caller's cached calendar events on this instance so the next fetch goes to the provider (callable).
+ * The cache is per instance, so the response says how long other instances may still serve their copies
+ */
+export const invalidateCalendarCache = onCall(
+  { cors: CORS_CONFIG.CALLABLE_CORS },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await authenticateCallable(request);
+
+    const cleared = invalidateCalendarEventCache(userId);
+    logger.info(`Cleared ${cleared} cached calendar fetches for user ${userId}`);
+    return {
+      success: true,
+      cleared,
+      maxStaleMs: CACHE_TTL.CALENDAR_EVENTS,
+      message: `Cleared on this instance; other instances may serve cached events for up to ${Math.round(CACHE_TTL.CALENDAR_EVENTS / 1000)}s until their copies expire`,
+    };
+  })
+);
+
+/**
+ * Integrations status function (callable) - connection state for every integration
+ */
+export const integrationsStatus = onCall(
+  { cors: CORS_CONFIG.CALLABLE_CORS },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await authenticateCallable(request);
+
+    return await getIntegrationsStatus(userId);
+  })
+);
+
+/**
+ * Calendar token sweeper (scheduled) - keeps idle Google connections from lapsing
+ */
+export const calendarTokenSweeper = onSchedule(
+  {
+    schedule: `every ${TOKEN_SWEEPER_CONFIG.INTERVAL_MINUTES} minutes`,
+    secrets: [googleClientId, googleClientSecret, tokenEncryptionKey],
+  },
+  async () => {
+    await sweepExpiringCalendarTokens();
   }
 );
 
@@ -218,50 +379,260 @@ export const calendarStatus = onCall(
  */
 export const getWeatherData = onCall(
   {
-    cors: true,
+    cors: CORS_CONFIG.CALLABLE_CORS,
     memory: "256MiB",
     timeoutSeconds: 30,
     secrets: [weatherApiKey, googleClientId, googleClientSecret],
   },
-  async (request) => {
-    return await getCurrentWeather(request.data);
-  }
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const view = resolveWeatherView(request.data?.view);
+    const userId = await resolveCallableUser(request);
+    const { request: weatherRequest, locationInferred } = await resolveRequestLocation(userId, allowRefreshForUser(userId, request.data));
+    const units = await resolveUserUnits(userId, weatherRequest?.units);
+    const weather = await getCurrentWeather({ ...weatherRequest, units });
+    return view === "minimal" ? toMinimalWeatherResponse(weather) : { ...weather, locationInferred };
+  })
+);
+
+/**
+ * Batch Weather Function - Current weather for several locations (dashboards), in request order
+ */
+export const getWeatherBatchFunction = onCall(
+  {
+    cors: CORS_CONFIG.CALLABLE_CORS,
+    memory: "256MiB",
+    timeoutSeconds: 60,
+    secrets: [weatherApiKey],
+  },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await resolveCallableUser(request);
+    const units = await resolveUserUnits(userId, request.data?.units);
+    return await getCurrentWeatherBatch({ ...request.data, units }, async (location) =>
+      allowRefreshForUser(userId, location.locationId !== undefined
+        ? { ...location, ...(await resolveSavedLocation(userId, location.locationId)) }
+        : location)
+    );
+  })
 );
 
 /**
- * Weather Forecast Function - Retrieves 5-day weather forecast
+ * Weather Forecast Function - Retrieves the daily forecast (as many days as the weather plan allows)
  */
 export const getWeatherForecastFunction = onCall(
   {
-    cors: true,
+    cors: CORS_CONFIG.CALLABLE_CORS,
     memory: "256MiB",
     timeoutSeconds: 30,
     secrets: [weatherApiKey, googleClientId, googleClientSecret],
   },
-  async (request) => {
-    return await getWeatherForecast(request.data);
-  }
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await resolveCallableUser(request);
+    const { request: forecastRequest, locationInferred } = await resolveRequestLocation(userId, allowRefreshForUser(userId, request.data));
+    const units = await resolveUserUnits(userId, forecastRequest?.units);
+    return { ...(await getWeatherForecast({ ...forecastRequest, units })), locationInferred };
+  })
 );
 
+/**
+ * Weather Summary Function - One-or-two-sentence summary for voice and glanceable clients
+ */
+export const getWeatherSummaryFunction = onCall(
+  {
+    cors: CORS_CONFIG.CALLABLE_CORS,
+    memory: "256MiB",
+    timeoutSeconds: 30,
+    secrets: [weatherApiKey],
+  },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await resolveCallableUser(request);
+    const { request: summaryRequest, locationInferred } = await resolveRequestLocation(userId, allowRefreshForUser(userId, request.data));
+    const units = await resolveUserUnits(userId, summaryRequest?.units);
+    return { ...(await getWeatherSummary({ ...summaryRequest, units })), locationInferred };
+  })
+);
+
+/**
+ * Air Quality Function - AQI and pollutant concentrations for the location
+ */
+export const getAirQualityFunction = onCall(
+  {
+    cors: CORS_CONFIG.CALLABLE_CORS,
+    memory: "256MiB",
+    timeoutSeconds: 30,
+    secrets: [weatherApiKey],
+  },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await resolveCallableUser(request);
+    const { request: airQualityRequest, locationInferred } = await resolveRequestLocation(userId, allowRefreshForUser(userId, request.data));
+    return { ...(await getAirQuality(airQualityRequest)), locationInferred };
+  })
+);
+
+// ============================================================================
+// SAVED LOCATION FUNCTIONS
+// ============================================================================
+
+/**
+ * Saved locations endpoint - list, create, update and delete a user's named locations
+ */
+export const savedLocations = onRequest((request, response) => runWithRequestContext(request, async () => {
+  if (handleCors(request, response, ["GET", "POST", "PUT", "DELETE", "OPTIONS"]) || handleUnknownPath(request, response)) {
+    return;
+  }
+
+  try {
+    const userId = await authenticateRequest(request, response);
+    if (!userId) {
+      return;
+    }
+    const locationId = typeof request.query.id === "string" ? request.query.id : "";
+
+    if (request.method === "GET") {
+      response.json({ success: true, locations: await listSavedLocations(userId) });
+    } else if (request.method === "POST") {
+      response.status(201).json({ success: true, location: await createSavedLocation(userId, request.body || {}) });
+    } else if (request.method === "PUT" || request.method === "DELETE") {
+      if (!locationId) {
+        response.status(400).json({ success: false, error: "Location id query parameter required" });
+        return;
+      }
+      if (request.method === "PUT") {
+        response.json({ success: true, location: await updateSavedLocation(userId, locationId, request.body || {}) });
+      } else {
+        await deleteSavedLocation(userId, locationId);
+        response.json({ success: true, message: "Saved location deleted" });
+      }
+    } else {
+      sendMethodNotAllowed(response, ["GET", "POST", "PUT", "DELETE", "OPTIONS"]);
+    }
+  } catch (error) {
+    // Validation, not-found and duplicate-name failures carry their own status
+    const status = error instanceof HttpsError ? error.httpErrorCode.status : 500;
+    if (status === 500) {
+      logger.error("Saved locations error:", error);
+    }
+    response.status(status).json({
+      success: false,
+      error: error instanceof Error ? error.message : "Unknown error"
+    });
+  }
+}));
+
 // ============================================================================
 // UTILITY FUNCTIONS
 // ============================================================================
 
+/**
+ * Cache stats for capacity planning - admins only (custom claim admin: true)
+ */
+export const cacheStats = onCall(
+  { cors: CORS_CONFIG.CALLABLE_CORS },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    await authenticateAdminCallable(request);
+    return { success: true, stats: await getCacheStats() };
+  })
+);
+
+/**
+ * Clear the cached weather, forecast, air quality and place name for a location - admins only
+ */
+export const clearWeatherCache = onCall<{latitude: number; longitude: number}>(
+  { cors: CORS_CONFIG.CALLABLE_CORS },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const adminId = await authenticateAdminCallable(request);
+    const { latitude, longitude } = request.data || {};
+    validateCoordinates(latitude, longitude);
+    await deleteCachedWeatherData(getLocationCacheKeys(latitude, longitude));
+    logger.info(`Admin ${adminId} cleared the weather cache for ${latitude},${longitude}`);
+    return { success: true };
+  })
+);
+
+/**
+ * Force-refresh one location's current weather and forecast in one unit system - admins only. Drops the
+ * cached entries, fetches fresh data through the normal path and returns it
+ */
+export const refreshWeatherCache = onCall<{latitude: number; longitude: number; units?: string}>(
+  { cors: CORS_CONFIG.CALLABLE_CORS, secrets: [weatherApiKey] },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const adminId = await authenticateAdminCallable(request);
+    const { latitude, longitude } = request.data || {};
+    validateCoordinates(latitude, longitude);
+    const units = normalizeUnits(request.data.units);
+
+    await deleteCachedWeatherData([
+      getCacheKey("current", latitude, longitude, units),
+      getForecastCacheKey(latitude, longitude, units),
+    ]);
+    const [current, forecast] = await Promise.all([
+      getCurrentWeather({ latitude, longitude, units, refresh: true }),
+      getWeatherForecast({ latitude, longitude, units, refresh: true, granularity: "both" }),
+    ]);
+    logger.info(`Admin ${adminId} refreshed cached weather for ${latitude},${longitude} (${units})`);
+    return { success: true, current: current.data, forecast: forecast.data };
+  })
+);
+
+/**
+ * Logout - revokes the user's refresh tokens so existing ID tokens stop working on bearer endpoints and callables
+ */
+export const logout = onCall(
+  { cors: CORS_CONFIG.CALLABLE_CORS },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await authenticateCallable(request);
+    await auth.revokeRefreshTokens(userId);
+    logger.info(`Revoked sessions for user ${userId}`);
+    return { success: true };
+  })
+);
+
+/**
+ * Delete account - permanently removes the signed-in user's data and Firebase Auth account, and revokes their
+ * Google calendar grant
+ */
+export const deleteAccount = onCall(
+  { cors: CORS_CONFIG.CALLABLE_CORS, secrets: [googleClientId, googleClientSecret, tokenEncryptionKey] },
+  (request) => runWithRequestContext(request.rawRequest, async () => {
+    const userId = await authenticateCallable(request);
+    await deleteUserAccount(userId);
+    return { success: true };
+  })
+);
+
 /**
  * Health check endpoint
  */
-export const healthCheck = onRequest((request, response) => {
+export const healthCheck = onRequest((request, response) => runWithRequestContext(request, () => {
+  if (handleUnknownPath(request, response)) {
+    return;
+  }
   response.json({
     status: "healthy",
     timestamp: new Date().toISOString(),
     functions: [
       "getCalendarEvents", 
       "getCalendarEventsWithAuthFunction", 
+      "getNextCalendarEventFunction",
+      "searchCalendarEventsFunction",
+      "getCalendarEventsWithWeatherFunction",
       "getWeatherData", 
+      "getWeatherBatchFunction",
       "getWeatherForecastFunction",
+      "getWeatherSummaryFunction",
+      "getAirQualityFunction",
       "oauthExchange", 
+      "oauthState",
       "calendarAuth", 
-      "calendarStatus"
+      "calendarExport",
+      "calendarStatus",
+      "invalidateCalendarCache",
+      "integrationsStatus",
+      "savedLocations",
+      "cacheStats",
+      "clearWeatherCache",
+      "refreshWeatherCache",
+      "logout",
+      "deleteAccount"
     ],
   });
-});
+}));
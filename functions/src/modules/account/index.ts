@@ -0,0 +1,26 @@
+// Account module - deleting a user and everything stored for them
+
+import * as logger from "firebase-functions/logger";
+import { auth, db } from "../../config";
+import { revokeCalendarGrant } from "../calendar";
+
+// Delete a user's account: revoke the Google calendar grant, then remove their profile, calendar tokens and saved
+// locations (the user document and its subcollections), pending OAuth states, and finally the Firebase Auth user.
+// Firestore has no soft delete, so this is permanent
+export async function deleteUserAccount(userId: string): Promise<void> {
+  // Needs the stored token, so it runs before the user document goes
+  await revokeCalendarGrant(userId);
+
+  await db.recursiveDelete(db.collection("users").doc(userId));
+
+  const states = await db.collection("oauth_states").where("userId", "==", userId).get();
+  if (!states.empty) {
+    const batch = db.batch();
+    states.docs.forEach((doc) => batch.delete(doc.ref));
+    await batch.commit();
+  }
+
+  // Last, so a failure above leaves a user who can sign in and retry; deleting also ends their sessions
+  await auth.deleteUser(userId);
+  logger.info(`Deleted account for user ${userId}`);
+}
@@ -0,0 +1,39 @@
+// OAuth state tests - run against the Firestore emulator (npm test)
+
+import { test } from "node:test";
+import * as assert from "node:assert/strict";
+import { db } from "../../config";
+import { createOAuthState, consumeOAuthState } from "./auth";
+
+test("consumeOAuthState accepts a fresh state for the user it was issued to", async () => {
+  const state = await createOAuthState("user-a");
+  assert.equal(await consumeOAuthState(state, "user-a"), "valid");
+});
+
+test("consumeOAuthState rejects a state issued to another user", async () => {
+  const state = await createOAuthState("user-a");
+  assert.equal(await consumeOAuthState(state, "user-b"), "wrong_user");
+  // The attempt still burns it, so the rightful user can't be raced with a replay either
+  assert.equal((await db.collection("oauth_states").doc(state).get()).exists, false);
+});
+
+test("consumeOAuthState rejects an expired state", async () => {
+  const state = "expired-state";
+  await db.collection("oauth_states").doc(state).set({
+    userId: "user-a",
+    createdAt: new Date(Date.now() - 20 * 60 * 1000).toISOString(),
+    expiresAt: new Date(Date.now() - 1000),
+  });
+  assert.equal(await consumeOAuthState(state, "user-a"), "invalid");
+});
+
+test("consumeOAuthState rejects a replayed state", async () => {
+  const state = await createOAuthState("user-a");
+  assert.equal(await consumeOAuthState(state, "user-a"), "valid");
+  assert.equal(await consumeOAuthState(state, "user-a"), "invalid");
+});
+
+test("consumeOAuthState rejects missing and unknown states", async () => {
+  assert.equal(await consumeOAuthState(undefined, "user-a"), "invalid");
+  assert.equal(await consumeOAuthState("never-issued", "user-a"), "invalid");
+});
@@ -1,40 +1,179 @@
 // Calendar authentication logic
+import { randomBytes } from "crypto";
 import * as logger from "firebase-functions/logger";
-import { db } from "../../config";
-import { CalendarEventsRequest, CalendarEventsResponse } from "../../types";
-import { getCalendarEventsWithToken } from "./events";
+import { HttpsError } from "firebase-functions/v2/https";
+import { db, CACHE_TTL, OAUTH_CONFIG, TOKEN_SWEEPER_CONFIG } from "../../config";
+import { CalendarEventsRequest, CalendarEventsResponse, CalendarProviderId, IntegrationStatus } from "../../types";
+import { getCalendarEventsWithToken, groupEventsByDay, resolveMaxResults } from "./events";
+import { refreshStoredCalendarToken } from "./sweeper";
+import { createGoogleOAuthClient } from "./oauth";
+import { calendarNotConnectedError, calendarReconnectRequiredError } from "./errors";
+import { decryptToken, encryptToken, needsTokenEncryption } from "../shared/crypto";
+import { isCacheValid } from "../shared/cache";
+import { requestLogFields } from "../shared/requestContext";
+
+// In-flight fetches per user + window, so double-taps and retries on this instance share one upstream call
+const inFlightEventFetches = new Map<string, Promise<CalendarEventsResponse>>();
+
+// Recent results per user + window, kept in memory only (event data never goes to the Firestore cache)
+const recentEventFetches = new Map<string, { result: CalendarEventsResponse; timestamp: number }>();
+
+// Per-user cache generation, bumped by every invalidation. A fetch only remembers its result if the generation
+// it started under is still current, so one in flight during a clear can't put pre-clear events back
+const eventCacheGenerations = new Map<string, number>();
+
+// Drop a user's cached and in-flight events on this instance (keys start with the user ID) and return how many
+// were dropped. Other instances' entries expire on their own within CACHE_TTL.CALENDAR_EVENTS
+export function invalidateCalendarEventCache(userId: string): number {
+  eventCacheGenerations.set(userId, (eventCacheGenerations.get(userId) || 0) + 1);
+
+  const isUsers = (key: string) => key.startsWith(`${userId}|`);
+  const recentKeys = Array.from(recentEventFetches.keys()).filter(isUsers);
+  const inFlightKeys = Array.from(inFlightEventFetches.keys()).filter(isUsers);
+  recentKeys.forEach((key) => recentEventFetches.delete(key));
+  // Callers already waiting still get the fetch's result; later ones start a fresh fetch instead of joining it
+  inFlightKeys.forEach((key) => inFlightEventFetches.delete(key));
+  return recentKeys.length + inFlightKeys.length;
+}
+
+// Helper function to remember a result, sweeping expired entries so the map doesn't grow with every window asked for
+function rememberEventFetch(fetchKey: string, result: CalendarEventsResponse): void {
+  recentEventFetches.forEach((entry, key) => {
+    if (!isCacheValid(entry.timestamp, CACHE_TTL.CALENDAR_EVENTS)) {
+      recentEventFetches.delete(key);
+    }
+  });
+  recentEventFetches.set(fetchKey, { result, timestamp: Date.now() });
+}
+
+// Get calendar events with automatic token retrieval, coalescing identical concurrent requests and serving
+// repeats within CACHE_TTL.CALENDAR_EVENTS from memory
+export function getCalendarEventsWithAuth(
+  userId: string,
+  request: CalendarEventsRequest
+): Promise<CalendarEventsResponse> {
+  const { timeMin, timeMax, calendarId = "primary", groupBy, timeZone, pageToken, q } = request;
+  const maxResults = resolveMaxResults(request.maxResults);
+  const fetchKey = [userId, calendarId, timeMin || "", timeMax || "", maxResults, groupBy || "", timeZone || "", pageToken || "", q || ""].join("|");
+
+  const recent = recentEventFetches.get(fetchKey);
+  if (recent && isCacheValid(recent.timestamp, CACHE_TTL.CALENDAR_EVENTS)) {
+    logger.info(`Returning cached calendar events for user ${userId}`);
+    return Promise.resolve(recent.result);
+  }
+
+  const inFlight = inFlightEventFetches.get(fetchKey);
+  if (inFlight) {
+    logger.info(`Joining in-flight calendar fetch for user ${userId}`);
+    return inFlight;
+  }
+
+  const generation = eventCacheGenerations.get(userId) || 0;
+  // Only remove our own entry - after an invalidation the key may belong to a newer fetch
+  const clear = () => {
+    if (inFlightEventFetches.get(fetchKey) === pending) {
+      inFlightEventFetches.delete(fetchKey);
+    }
+  };
+  const pending: Promise<CalendarEventsResponse> = fetchCalendarEventsWithAuth(userId, { ...request, maxResults }).then(
+    (result) => {
+      clear();
+      if ((eventCacheGenerations.get(userId) || 0) === generation) {
+        rememberEventFetch(fetchKey, result);
+      }
+      return result;
+    },
+    (error) => {
+      clear();
+      throw error;
+    }
+  );
+
+  inFlightEventFetches.set(fetchKey, pending);
+  return pending;
+}
 
 // Get calendar events with automatic token retrieval from Firestore
-export async function getCalendarEventsWithAuth(
+async function fetchCalendarEventsWithAuth(
   userId: string,
   request: CalendarEventsRequest
 ): Promise<CalendarEventsResponse> {
   try {
-    const { timeMin, timeMax, maxResults = 10, calendarId = "primary" } = request;
+    const { timeMin, timeMax, maxResults, calendarId = "primary", pageToken, q } = request;
 
     // Get stored calendar token from Firestore
     const userDoc = await db.collection("users").doc(userId).get();
     if (!userDoc.exists) {
-      throw new Error("User not found");
+      throw calendarNotConnectedError();
     }
 
     const userData = userDoc.data();
-    const calendarToken = userData?.googleCalendarToken?.access_token;
+    const storedToken = userData?.googleCalendarToken;
+    const provider: CalendarProviderId = storedToken?.provider || "google";
 
-    if (!calendarToken) {
-      throw new Error("No calendar access token found. Please connect to Google Calendar first.");
+    if (!storedToken?.access_token) {
+      throw calendarNotConnectedError();
+    }
+
+    let calendarToken: string | null = decryptToken(storedToken.access_token);
+    const refreshToken = storedToken.refresh_token ? decryptToken(storedToken.refresh_token) : undefined;
+
+    // Tokens written before encryption (or directly by the client) are re-encrypted the first time they're read
+    if (needsTokenEncryption(storedToken.access_token) || (storedToken.refresh_token && needsTokenEncryption(storedToken.refresh_token))) {
+      await db.collection("users").doc(userId).update({
+        "googleCalendarToken.access_token": encryptToken(calendarToken),
+        ...(refreshToken && { "googleCalendarToken.refresh_token": encryptToken(refreshToken) }),
+      });
+      logger.info(`Encrypted legacy calendar tokens for user ${userId}`);
+    }
+
+    if (provider === "google") {
+      if (storedToken.needsReconnect) {
+        throw calendarReconnectRequiredError();
+      }
+
+      // Refresh an expired (or about to expire) Google token here and persist it, so it isn't refreshed every call
+      const expiresSoon = !!storedToken.expiry_date && storedToken.expiry_date < Date.now() + TOKEN_SWEEPER_CONFIG.REFRESH_LEAD_MS;
+      if (expiresSoon && refreshToken) {
+        logger.info(`Refreshing expired Google calendar token for user ${userId}`);
+        calendarToken = await refreshStoredCalendarToken(userId, refreshToken);
+        if (!calendarToken) {
+          throw calendarReconnectRequiredError();
+        }
+      }
     }
 
     // Use the existing events function with the retrieved token
-    return await getCalendarEventsWithToken({
+    const result = await getCalendarEventsWithToken({
       accessToken: calendarToken,
+      provider,
       calendarId,
       timeMin,
       timeMax,
       maxResults,
+      pageToken,
+      q,
     });
+
+    if (request.groupBy !== "day") {
+      return result;
+    }
+
+    const timeZone = request.timeZone || userData?.preferences?.timezone || "UTC";
+    try {
+      return { ...result, days: groupEventsByDay(result.events, timeZone) };
+    } catch {
+      // Intl rejects unknown zones - fall back to UTC rather than failing the whole request
+      logger.warn(`Unknown time zone ${timeZone} for day grouping, using UTC`);
+      return { ...result, days: groupEventsByDay(result.events, "UTC") };
+    }
   } catch (error) {
-    logger.error("Error fetching calendar events with auth:", error);
+    logger.error("Error fetching calendar events with auth:", error, requestLogFields());
+    // Typed errors pass through unchanged - not connected, reconnect required, and provider token rejections,
+    // which getCalendarEventsWithToken has already classified as reconnect required
+    if (error instanceof HttpsError) {
+      throw error;
+    }
     throw new Error(`Failed to fetch calendar events: ${error instanceof Error ? error.message : "Unknown error"}`);
   }
 }
@@ -56,6 +195,25 @@ export async function checkCalendarAccess(userId: string): Promise<boolean> {
   }
 }
 
+// Get the calendar connection status for the integrations overview
+export async function getCalendarIntegrationStatus(userId: string): Promise<IntegrationStatus> {
+  const userDoc = await db.collection("users").doc(userId).get();
+  const token = userDoc.exists ? userDoc.data()?.googleCalendarToken : null;
+
+  if (!token?.access_token) {
+    return { connected: false, needsReconnect: false, lastSync: null };
+  }
+
+  // An expired access token is only recoverable if we hold a refresh token
+  const expired = !!token.expiry_date && token.expiry_date < Date.now();
+
+  return {
+    connected: true,
+    needsReconnect: !!token.needsReconnect || (expired && !token.refresh_token),
+    lastSync: token.lastUpdated || null,
+  };
+}
+
 // Store Google Calendar tokens in Firestore
 export async function storeCalendarTokens(
   userId: string,
@@ -65,24 +223,30 @@ export async function storeCalendarTokens(
     scope: string;
     token_type?: string;
     expiry_date?: number;
-  }
+  },
+  provider: CalendarProviderId = "google"
 ): Promise<void> {
   try {
     const tokenData = {
-      access_token: tokens.access_token,
+      // Credentials are encrypted at rest; everything else stays queryable
+      access_token: encryptToken(tokens.access_token),
       scope: tokens.scope,
       type: "oauth_token",
+      provider,
       lastUpdated: new Date().toISOString(),
-      ...(tokens.refresh_token && { refresh_token: tokens.refresh_token }),
+      ...(tokens.refresh_token && { refresh_token: encryptToken(tokens.refresh_token) }),
       ...(tokens.token_type && { token_type: tokens.token_type }),
       ...(tokens.expiry_date && { expiry_date: tokens.expiry_date }),
     };
 
+    // Replace the whole token map (the rest of the profile is kept) so a reconnect clears needsReconnect and
+    // any expiry or refresh token left over from the previous grant
     await db.collection("users").doc(userId).set({
       googleCalendarToken: tokenData
-    }, { merge: true });
+    }, { mergeFields: ["googleCalendarToken"] });
+    invalidateCalendarEventCache(userId);
 
-    logger.info(`Stored Google Calendar tokens for user ${userId}`);
+    logger.info(`Stored ${provider} calendar tokens for user ${userId}`);
   } catch (error) {
     logger.error("Error storing Google Calendar tokens:", error);
     throw error;
@@ -95,6 +259,7 @@ export async function clearCalendarTokens(userId: string): Promise<void> {
     await db.collection("users").doc(userId).update({
       googleCalendarToken: null
     });
+    invalidateCalendarEventCache(userId);
 
     logger.info(`Cleared OAuth token for user ${userId}`);
   } catch (error) {
@@ -102,3 +267,70 @@ export async function clearCalendarTokens(userId: string): Promise<void> {
     throw error;
   }
 }
+
+// Revoke the user's Google grant so the app no longer appears under their Google account's third-party access.
+// Best effort - a token Google already considers invalid is as good as revoked. Other providers have no grant to revoke
+export async function revokeCalendarGrant(userId: string): Promise<void> {
+  const userDoc = await db.collection("users").doc(userId).get();
+  const storedToken = userDoc.exists ? userDoc.data()?.googleCalendarToken : null;
+  if (!storedToken?.access_token || (storedToken.provider || "google") !== "google") {
+    return;
+  }
+
+  try {
+    // Revoking the refresh token revokes the whole grant, access tokens included
+    const token = decryptToken(storedToken.refresh_token || storedToken.access_token);
+    await createGoogleOAuthClient().revokeToken(token);
+    logger.info(`Revoked Google calendar grant for user ${userId}`);
+  } catch (error) {
+    logger.warn(`Could not revoke Google calendar grant for user ${userId}:`, error instanceof Error ? error.message : error);
+  }
+}
+
+// Resolve the OAuth redirect URI, accepting only exact matches from the allowlist
+export function resolveRedirectUri(requestedUri?: string): string | null {
+  // The configured default is always on the allowlist - config load rejects a deployment where it isn't
+  const uri = requestedUri || OAUTH_CONFIG.DEFAULT_REDIRECT_URI;
+  return OAUTH_CONFIG.ALLOWED_REDIRECT_URIS.includes(uri) ? uri : null;
+}
+
+// Issue a single-use OAuth state value for the user's connect flow, stored server-side with an expiry
+export async function createOAuthState(userId: string): Promise<string> {
+  const state = randomBytes(32).toString("base64url");
+  const now = Date.now();
+
+  // expiresAt is a Timestamp so a Firestore TTL policy can purge abandoned states
+  await db.collection("oauth_states").doc(state).set({
+    userId,
+    createdAt: new Date(now).toISOString(),
+    expiresAt: new Date(now + OAUTH_CONFIG.STATE_TTL_MS),
+  });
+
+  return state;
+}
+
+// Outcome of redeeming an OAuth state value
+export type OAuthStateResult = "valid" | "invalid" | "wrong_user";
+
+// Redeem an OAuth state value - valid only once, only before it expires, and only for the user it was
+// issued to. Any presentation consumes it, so a leaked value can't be retried by anyone
+export async function consumeOAuthState(state: unknown, userId: string): Promise<OAuthStateResult> {
+  if (typeof state !== "string" || !state) {
+    return "invalid";
+  }
+
+  const stateRef = db.collection("oauth_states").doc(state);
+  return await db.runTransaction(async (transaction): Promise<OAuthStateResult> => {
+    const doc = await transaction.get(stateRef);
+    if (!doc.exists) {
+      return "invalid";
+    }
+
+    transaction.delete(stateRef);
+    const expiresAt = doc.get("expiresAt");
+    if (!expiresAt || expiresAt.toMillis() <= Date.now()) {
+      return "invalid";
+    }
+    return doc.get("userId") === userId ? "valid" : "wrong_user";
+  });
+}
@@ -0,0 +1,84 @@
+// Calendar cache invalidation tests - run against the Firestore emulator (npm test), with the Google provider stubbed
+
+import { afterEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { db } from "../../config";
+import { getCalendarEventsWithAuth, invalidateCalendarEventCache } from "./auth";
+import { googleCalendarProvider } from "./providers";
+
+const originalListEvents = googleCalendarProvider.listEvents;
+afterEach(() => {
+  googleCalendarProvider.listEvents = originalListEvents;
+});
+
+async function seedUser(userId: string): Promise<void> {
+  await db.collection("users").doc(userId).set({
+    googleCalendarToken: {
+      access_token: `${userId}-access`,
+      expiry_date: Date.now() + 60 * 60 * 1000,
+      provider: "google",
+    },
+  });
+}
+
+test("invalidation drops only the caller's cached fetches and reports how many", async () => {
+  await seedUser("cache-user-a");
+  await seedUser("cache-user-b");
+
+  let calls = 0;
+  googleCalendarProvider.listEvents = async () => {
+    calls++;
+    return { events: [], nextPageToken: null };
+  };
+
+  await getCalendarEventsWithAuth("cache-user-a", { timeMin: "2026-03-01T00:00:00Z" });
+  await getCalendarEventsWithAuth("cache-user-a", { timeMin: "2026-03-02T00:00:00Z" });
+  await getCalendarEventsWithAuth("cache-user-b", { timeMin: "2026-03-01T00:00:00Z" });
+  assert.equal(calls, 3);
+
+  assert.equal(invalidateCalendarEventCache("cache-user-a"), 2);
+  assert.equal(invalidateCalendarEventCache("cache-user-a"), 0);
+
+  await getCalendarEventsWithAuth("cache-user-a", { timeMin: "2026-03-01T00:00:00Z" });
+  assert.equal(calls, 4);
+
+  await getCalendarEventsWithAuth("cache-user-b", { timeMin: "2026-03-01T00:00:00Z" });
+  assert.equal(calls, 4);
+});
+
+test("a fetch in flight during invalidation isn't remembered, and later requests don't join it", async () => {
+  await seedUser("cache-user-c");
+
+  const releases: (() => void)[] = [];
+  let calls = 0;
+  googleCalendarProvider.listEvents = () => {
+    const call = ++calls;
+    return new Promise((resolve) => {
+      releases.push(() => resolve({ events: [{ id: `fetch-${call}`, summary: "Review", start: {}, end: {} }], nextPageToken: null }));
+    });
+  };
+  // Helper function to wait for the token read so the provider call has been made
+  const providerCalled = async (count: number) => {
+    for (let waited = 0; calls < count && waited < 2000; waited += 10) {
+      await new Promise((resolve) => setTimeout(resolve, 10));
+    }
+  };
+
+  const window = { timeMin: "2026-03-05T00:00:00Z" };
+  const stale = getCalendarEventsWithAuth("cache-user-c", window);
+  await providerCalled(1);
+
+  assert.equal(invalidateCalendarEventCache("cache-user-c"), 1);
+  const fresh = getCalendarEventsWithAuth("cache-user-c", window);
+  await providerCalled(2);
+  assert.equal(calls, 2);
+
+  releases[1]();
+  releases[0]();
+  assert.equal((await stale).events[0].id, "fetch-1");
+  assert.equal((await fresh).events[0].id, "fetch-2");
+
+  // The post-clear result is the one served from memory, not the one that finished last
+  assert.equal((await getCalendarEventsWithAuth("cache-user-c", window)).events[0].id, "fetch-2");
+  assert.equal(calls, 2);
+});
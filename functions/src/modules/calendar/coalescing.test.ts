@@ -0,0 +1,99 @@
+// Calendar fetch coalescing tests - run against the Firestore emulator (npm test), with the Google provider stubbed
+// to hold its response until the test releases it, so the calls really overlap
+
+import { afterEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { db } from "../../config";
+import { getCalendarEventsWithAuth, invalidateCalendarEventCache } from "./auth";
+import { CalendarEventPage, googleCalendarProvider } from "./providers";
+
+const originalListEvents = googleCalendarProvider.listEvents;
+afterEach(() => {
+  googleCalendarProvider.listEvents = originalListEvents;
+});
+
+async function seedUser(userId: string): Promise<void> {
+  await db.collection("users").doc(userId).set({
+    googleCalendarToken: {
+      access_token: `${userId}-access`,
+      expiry_date: Date.now() + 60 * 60 * 1000,
+      provider: "google",
+    },
+  });
+}
+
+// Helper function to stub the provider with calls that stay pending until released (or failed)
+function heldProvider() {
+  const calls: { release: (result: CalendarEventPage) => void; fail: (error: Error) => void }[] = [];
+  googleCalendarProvider.listEvents = () => new Promise<CalendarEventPage>((resolve, reject) => {
+    calls.push({ release: resolve, fail: reject });
+  });
+  return calls;
+}
+
+// Helper function to wait until the token reads are done and the expected provider calls have been made,
+// then a little longer so any extra (uncoalesced) call would show up too
+async function settle(calls: unknown[], expected: number): Promise<void> {
+  for (let waited = 0; calls.length < expected && waited < 2000; waited += 10) {
+    await new Promise((resolve) => setTimeout(resolve, 10));
+  }
+  await new Promise((resolve) => setTimeout(resolve, 50));
+}
+
+test("identical concurrent requests share one provider fetch and one result", async () => {
+  await seedUser("coalesce-same");
+  const calls = heldProvider();
+
+  const window = { timeMin: "2026-04-01T00:00:00Z", timeMax: "2026-04-02T00:00:00Z" };
+  const pending = [1, 2, 3].map(() => getCalendarEventsWithAuth("coalesce-same", window));
+  await settle(calls, 1);
+  assert.equal(calls.length, 1);
+
+  calls[0].release({ events: [{ id: "e1", summary: "Standup", start: { dateTime: "2026-04-01T09:00:00Z" }, end: {} }], nextPageToken: null });
+  const [first, second, third] = await Promise.all(pending);
+  assert.equal(first.count, 1);
+  assert.equal(second, first);
+  assert.equal(third, first);
+  invalidateCalendarEventCache("coalesce-same");
+});
+
+test("different windows and different users are fetched separately", async () => {
+  await seedUser("coalesce-a");
+  await seedUser("coalesce-b");
+  const calls = heldProvider();
+
+  const pending = [
+    getCalendarEventsWithAuth("coalesce-a", { timeMin: "2026-04-01T00:00:00Z" }),
+    getCalendarEventsWithAuth("coalesce-a", { timeMin: "2026-04-02T00:00:00Z" }),
+    getCalendarEventsWithAuth("coalesce-b", { timeMin: "2026-04-01T00:00:00Z" }),
+  ];
+  await settle(calls, 3);
+  assert.equal(calls.length, 3);
+
+  calls.forEach((call) => call.release({ events: [], nextPageToken: null }));
+  await Promise.all(pending);
+  invalidateCalendarEventCache("coalesce-a");
+  invalidateCalendarEventCache("coalesce-b");
+});
+
+test("a failed shared fetch rejects every joined caller and isn't remembered", async () => {
+  await seedUser("coalesce-fail");
+  const calls = heldProvider();
+
+  const window = { timeMin: "2026-04-03T00:00:00Z" };
+  const pending = [1, 2].map(() => getCalendarEventsWithAuth("coalesce-fail", window));
+  await settle(calls, 1);
+  assert.equal(calls.length, 1);
+
+  calls[0].fail(new Error("upstream timeout"));
+  for (const request of pending) {
+    await assert.rejects(request, /upstream timeout/);
+  }
+
+  const retry = getCalendarEventsWithAuth("coalesce-fail", window);
+  await settle(calls, 2);
+  assert.equal(calls.length, 2);
+  calls[1].release({ events: [], nextPageToken: null });
+  assert.equal((await retry).count, 0);
+  invalidateCalendarEventCache("coalesce-fail");
+});
@@ -0,0 +1,28 @@
+// Typed calendar errors - callers map these by their details.code
+
+import { HttpsError } from "firebase-functions/v2/https";
+
+// Stored credentials can't be refreshed (revoked or flagged), so the user has to reconnect
+export function calendarReconnectRequiredError(): HttpsError {
+  return new HttpsError(
+    "unauthenticated",
+    "Calendar access has expired or been revoked. Please reconnect your calendar.",
+    { code: "calendar_reconnect_required" }
+  );
+}
+
+// The user has no calendar connected (or no profile yet) - distinct from a server failure
+export function calendarNotConnectedError(): HttpsError {
+  return new HttpsError(
+    "not-found",
+    "No calendar connected. Please connect a calendar first.",
+    { code: "calendar_not_connected" }
+  );
+}
+
+// Helper function to tell a provider rejecting the access token (HTTP 401 from Google or Graph) apart from other
+// failures - the connection won't work again until the user reconnects
+export function isProviderAuthError(error: unknown): boolean {
+  const response = (error as { response?: { status?: unknown } } | null)?.response;
+  return response?.status === 401;
+}
@@ -0,0 +1,109 @@
+// Upcoming events annotated with the forecast where (and when) each one happens
+
+import * as logger from "firebase-functions/logger";
+import { CALENDAR_CONFIG, weatherApiKey } from "../../config";
+import { CalendarEvent, CalendarEventWithWeather, EventWeatherRequest, EventWeatherResponse, ForecastData, ForecastHour, WeatherAtEvent } from "../../types";
+import { geocodeQuery } from "../shared/location";
+import { normalizeUnits, Units } from "../shared/units";
+import { getWeatherForecast } from "../weather";
+import { resolveRequestLocation } from "../locations";
+import { getCalendarEventsWithAuth } from "./auth";
+
+// Look ahead no further than the forecast covers
+const EVENT_WEATHER_WINDOW_MS = 5 * 24 * 60 * 60 * 1000;
+const FORECAST_SLOT_MS = 3 * 60 * 60 * 1000;
+
+type Coordinates = { latitude: number; longitude: number };
+
+// Helper function to read the weather API key - geocoding event locations needs a real one
+function getWeatherApiKey(): string {
+  try {
+    return weatherApiKey.value().trim();
+  } catch {
+    return process.env.WEATHER_API_KEY || "";
+  }
+}
+
+// Helper function to pick the event's weather out of a forecast for its location
+function weatherForEvent(event: CalendarEvent, forecast: ForecastData, source: WeatherAtEvent["source"], coordinates: Coordinates): WeatherAtEvent {
+  // Google returns offset-local dateTimes, so the first 10 characters are the event's local date
+  const eventDate = (event.start.date || event.start.dateTime || "").slice(0, 10);
+  const day = forecast.days.find((candidate) => candidate.date === eventDate) || null;
+
+  let atStart: ForecastHour | null = null;
+  if (event.start.dateTime) {
+    const start = new Date(event.start.dateTime).getTime();
+    // The latest entry starting at or before the event - hourly entries are 3 hours apart, or 1 on One Call 3.0
+    atStart = (forecast.hourly || []).filter((hour) => {
+      const slotStart = new Date(hour.time).getTime();
+      return start >= slotStart && start < slotStart + FORECAST_SLOT_MS;
+    }).pop() || null;
+  }
+
+  return { source, ...coordinates, atStart, day };
+}
+
+// Get the user's upcoming events, each with the forecast for its location (geocoded from the event's location
+// text) or, when it has none or it can't be found, the user's default saved location
+export async function getCalendarEventsWithWeather(userId: string, request: EventWeatherRequest): Promise<EventWeatherResponse> {
+  const units: Units = normalizeUnits(request?.units);
+  const now = new Date();
+  const { events } = await getCalendarEventsWithAuth(userId, {
+    timeMin: now.toISOString(),
+    timeMax: new Date(now.getTime() + EVENT_WEATHER_WINDOW_MS).toISOString(),
+    maxResults: request?.maxResults,
+  });
+
+  const apiKey = getWeatherApiKey();
+  const { request: fallback } = await resolveRequestLocation(userId, {} as Partial<Coordinates>);
+  const defaultCoordinates: Coordinates = { latitude: fallback.latitude as number, longitude: fallback.longitude as number };
+
+  // One geocode per distinct location text and one forecast per set of coordinates, however many events share them
+  const geocoded = new Map<string, Promise<Coordinates | null>>();
+  const forecasts = new Map<string, Promise<ForecastData | null>>();
+
+  const geocode = (location: string): Promise<Coordinates | null> => {
+    const key = location.trim().toLowerCase();
+    if (!geocoded.has(key)) {
+      if (!apiKey || geocoded.size >= CALENDAR_CONFIG.MAX_EVENT_GEOCODES) {
+        return Promise.resolve(null);
+      }
+      // Event locations are the user's private data, so they're geocoded without the shared cache and only
+      // remembered for this request (and never logged)
+      geocoded.set(key, geocodeQuery(location, apiKey, { persist: false }).catch((error) => {
+        // The error message would repeat the location text, so only the status (if any) is logged
+        logger.info("Could not geocode an event location, using the default location", { status: (error as { response?: { status?: number } })?.response?.status ?? null });
+        return null;
+      }));
+    }
+    return geocoded.get(key) as Promise<Coordinates | null>;
+  };
+
+  const forecastFor = (coordinates: Coordinates): Promise<ForecastData | null> => {
+    const key = `${coordinates.latitude},${coordinates.longitude}`;
+    if (!forecasts.has(key)) {
+      forecasts.set(key, getWeatherForecast({ ...coordinates, units, granularity: "both" }).then(
+        (forecast) => forecast.data,
+        (error) => {
+          logger.warn(`Forecast unavailable for ${key}, events there are returned without weather:`, error);
+          return null;
+        }
+      ));
+    }
+    return forecasts.get(key) as Promise<ForecastData | null>;
+  };
+
+  const annotated: CalendarEventWithWeather[] = await Promise.all(events.map(async (event) => {
+    const eventCoordinates = event.location ? await geocode(event.location) : null;
+    const coordinates = eventCoordinates || defaultCoordinates;
+    const forecast = await forecastFor(coordinates);
+    return {
+      ...event,
+      weatherAtEvent: forecast
+        ? weatherForEvent(event, forecast, eventCoordinates ? "event_location" : "default_location", coordinates)
+        : null,
+    };
+  }));
+
+  return { success: true, events: annotated, count: annotated.length };
+}
@@ -0,0 +1,129 @@
+// Calendar event listing tests - request validation and day grouping, with the Google provider stubbed
+
+import { afterEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { isDeepStrictEqual as isEqual } from "util";
+import { HttpsError } from "firebase-functions/v2/https";
+import { CALENDAR_CONFIG } from "../../config";
+import { CalendarEvent } from "../../types";
+import { getCalendarEventsWithToken, groupEventsByDay, resolveMaxResults } from "./events";
+import { CalendarListOptions, googleCalendarProvider } from "./providers";
+
+const originalListEvents = googleCalendarProvider.listEvents;
+const originalLimits = { default: CALENDAR_CONFIG.DEFAULT_MAX_RESULTS, cap: CALENDAR_CONFIG.MAX_RESULTS_CAP };
+afterEach(() => {
+  googleCalendarProvider.listEvents = originalListEvents;
+  CALENDAR_CONFIG.DEFAULT_MAX_RESULTS = originalLimits.default;
+  CALENDAR_CONFIG.MAX_RESULTS_CAP = originalLimits.cap;
+});
+
+// Helper function to match an invalid-argument error
+const invalidArgument = (error: unknown) => error instanceof HttpsError && error.code === "invalid-argument";
+
+test("maxResults defaults to the configured value when absent", () => {
+  CALENDAR_CONFIG.DEFAULT_MAX_RESULTS = 15;
+  assert.equal(resolveMaxResults(undefined), 15);
+  assert.equal(resolveMaxResults(null), 15);
+  assert.equal(resolveMaxResults(""), 15);
+});
+
+test("maxResults accepts integers up to the cap, including numeric strings from query parameters", () => {
+  CALENDAR_CONFIG.MAX_RESULTS_CAP = 100;
+  assert.equal(resolveMaxResults(1), 1);
+  assert.equal(resolveMaxResults(100), 100);
+  assert.equal(resolveMaxResults("25"), 25);
+});
+
+test("maxResults beyond the cap, zero, negative, fractional or junk is invalid-argument", () => {
+  CALENDAR_CONFIG.MAX_RESULTS_CAP = 100;
+  assert.throws(() => resolveMaxResults(101), (error: unknown) => invalidArgument(error) && (error as Error).message.includes("at most 100"));
+  [0, -3, 2.5, "ten", true, NaN].forEach((value) => assert.throws(() => resolveMaxResults(value), invalidArgument));
+});
+
+test("the provider is asked for the resolved maxResults", async () => {
+  CALENDAR_CONFIG.DEFAULT_MAX_RESULTS = 7;
+  const requested: CalendarListOptions[] = [];
+  googleCalendarProvider.listEvents = async (_accessToken, options) => {
+    requested.push(options);
+    return { events: [], nextPageToken: null };
+  };
+
+  await getCalendarEventsWithToken({ accessToken: "token" });
+  await getCalendarEventsWithToken({ accessToken: "token", maxResults: 30 });
+  assert.deepEqual(requested.map((options) => options.maxResults), [7, 30]);
+
+  await assert.rejects(getCalendarEventsWithToken({ accessToken: "token", maxResults: 0 }), invalidArgument);
+  assert.equal(requested.length, 2);
+});
+
+// Helper function to build a timed or all-day event
+function event(id: string, start: CalendarEvent["start"]): CalendarEvent {
+  return { id, summary: id, start, end: start };
+}
+
+test("timed events are grouped by their date in the requested time zone", () => {
+  const events = [
+    event("late", { dateTime: "2026-03-10T03:30:00Z" }), // Evening of the 9th in Chicago
+    event("morning", { dateTime: "2026-03-10T15:00:00Z" }),
+  ];
+  assert.deepEqual(groupEventsByDay(events, "America/Chicago").map((day) => [day.date, day.events.map((e) => e.id)]),
+    [["2026-03-09", ["late"]], ["2026-03-10", ["morning"]]]);
+  assert.deepEqual(groupEventsByDay(events, "UTC").map((day) => [day.date, day.events.map((e) => e.id)]),
+    [["2026-03-10", ["late", "morning"]]]);
+});
+
+test("all-day events keep their calendar date whatever the time zone", () => {
+  const events = [event("holiday", { date: "2026-12-25" }), event("call", { dateTime: "2026-12-25T23:30:00-05:00" })];
+  assert.deepEqual(groupEventsByDay(events, "Asia/Tokyo").map((day) => [day.date, day.events.map((e) => e.id)]),
+    [["2026-12-25", ["holiday"]], ["2026-12-26", ["call"]]]);
+});
+
+test("days come out in date order, events keep their order, and undated events are skipped", () => {
+  const events = [
+    event("b", { dateTime: "2026-05-02T09:00:00Z" }),
+    event("a", { dateTime: "2026-05-01T09:00:00Z" }),
+    event("undated", {}),
+    event("c", { dateTime: "2026-05-02T08:00:00Z" }),
+  ];
+  assert.deepEqual(groupEventsByDay(events, "UTC").map((day) => [day.date, day.events.map((e) => e.id)]),
+    [["2026-05-01", ["a"]], ["2026-05-02", ["b", "c"]]]);
+  assert.deepEqual(groupEventsByDay([], "UTC"), []);
+});
+
+test("unknown time zones are rejected by Intl, which the caller turns into a UTC fallback", () => {
+  assert.throws(() => groupEventsByDay([event("a", { dateTime: "2026-05-01T09:00:00Z" })], "Mars/Olympus_Mons"), RangeError);
+});
+
+// Helper function to build a provider failure the way axios and gaxios report one
+function providerFailure(status: number, data: object = {}): Error {
+  return Object.assign(new Error(`Request failed with status code ${status}`), { response: { status, data } });
+}
+
+// Helper function to match the typed reconnect-required error
+const reconnectRequired = (error: unknown) =>
+  error instanceof HttpsError && error.code === "unauthenticated" && isEqual(error.details, { code: "calendar_reconnect_required" });
+
+test("a provider 401 or revoked grant is reported as reconnect required, not a plain error", async () => {
+  for (const failure of [providerFailure(401, { error: { code: 401, status: "UNAUTHENTICATED" } }), providerFailure(400, { error: "invalid_grant" })]) {
+    googleCalendarProvider.listEvents = async () => {
+      throw failure;
+    };
+    await assert.rejects(getCalendarEventsWithToken({ accessToken: "revoked-token" }), reconnectRequired);
+  }
+});
+
+test("typed errors pass through and other failures stay plain errors", async () => {
+  const typed = new HttpsError("not-found", "Calendar not found", { code: "calendar_not_found" });
+  googleCalendarProvider.listEvents = async () => {
+    throw typed;
+  };
+  await assert.rejects(getCalendarEventsWithToken({ accessToken: "token" }), (error: unknown) => error === typed);
+
+  googleCalendarProvider.listEvents = async () => {
+    throw providerFailure(503);
+  };
+  await assert.rejects(getCalendarEventsWithToken({ accessToken: "token" }),
+    (error: unknown) => !(error instanceof HttpsError) && /Failed to fetch calendar events: Request failed with status code 503/.test((error as Error).message));
+
+  await assert.rejects(getCalendarEventsWithToken({ accessToken: "" }), invalidArgument);
+});
@@ -1,71 +1,88 @@
 // Calendar events logic
 
-import { google } from "googleapis";
 import * as logger from "firebase-functions/logger";
-import { CalendarRequest, CalendarEvent, CalendarEventsResponse } from "../../types";
+import { HttpsError } from "firebase-functions/v2/https";
+import { CALENDAR_CONFIG } from "../../config";
+import { CalendarRequest, CalendarEvent, CalendarDay, CalendarEventsResponse } from "../../types";
+import { getCalendarProvider } from "./providers";
+import { calendarReconnectRequiredError, isProviderAuthError } from "./errors";
+import { isRevokedGrantError } from "./sweeper";
+import { requestLogFields } from "../shared/requestContext";
+
+// Validate maxResults, applying the configured default when absent (0 or junk is an error, not "fetch nothing")
+export function resolveMaxResults(value: unknown): number {
+  if (value === undefined || value === null || value === "") {
+    return CALENDAR_CONFIG.DEFAULT_MAX_RESULTS;
+  }
+
+  const maxResults = typeof value === "string" ? Number(value) : value;
+  if (typeof maxResults !== "number" || !Number.isInteger(maxResults) || maxResults < 1) {
+    throw new HttpsError("invalid-argument", `maxResults must be a positive integer, got ${JSON.stringify(value)}`);
+  }
+  if (maxResults > CALENDAR_CONFIG.MAX_RESULTS_CAP) {
+    throw new HttpsError("invalid-argument", `maxResults must be at most ${CALENDAR_CONFIG.MAX_RESULTS_CAP}, got ${maxResults}`);
+  }
+
+  return maxResults;
+}
 
 // Get calendar events using provided access token
 export async function getCalendarEventsWithToken(request: CalendarRequest): Promise<CalendarEventsResponse> {
+  const maxResults = resolveMaxResults(request.maxResults);
+
   try {
-    const { accessToken, calendarId = "primary", timeMin, timeMax, maxResults = 10 } = request;
+    const { accessToken, provider = "google", calendarId = "primary", timeMin, timeMax, pageToken, q } = request;
 
     if (!accessToken) {
-      throw new Error("Access token is required");
+      throw new HttpsError("invalid-argument", "Access token is required");
     }
 
-    // Initialize Google Calendar API
-    const auth = new google.auth.OAuth2();
-    auth.setCredentials({ access_token: accessToken });
-
-    const calendar = google.calendar({ version: "v3", auth });
-
-    // Prepare parameters
-    const params: {
-      calendarId: string;
-      maxResults: number;
-      singleEvents: boolean;
-      orderBy: string;
-      timeMin?: string;
-      timeMax?: string;
-    } = {
+    // Fetch events from the connection's provider
+    const { events: formattedEvents, nextPageToken } = await getCalendarProvider(provider).listEvents(accessToken, {
       calendarId,
       maxResults,
-      singleEvents: true,
-      orderBy: "startTime",
-    };
+      timeMin,
+      timeMax,
+      pageToken,
+      q,
+    });
 
-    if (timeMin) params.timeMin = timeMin;
-    if (timeMax) params.timeMax = timeMax;
-
-    // Fetch events
-    const response = await calendar.events.list(params);
-    const events = response.data.items || [];
-
-    // Transform events to our format
-    const formattedEvents: CalendarEvent[] = events.map((event) => ({
-      id: event.id || "",
-      summary: event.summary || "No title",
-      start: {
-        dateTime: event.start?.dateTime,
-        date: event.start?.date,
-      },
-      end: {
-        dateTime: event.end?.dateTime,
-        date: event.end?.date,
-      },
-      location: event.location,
-      description: event.description,
-    }));
-
-    logger.info(`Retrieved ${formattedEvents.length} calendar events`);
+    logger.info(`Retrieved ${formattedEvents.length} ${provider} calendar events`);
 
     return {
       success: true,
       events: formattedEvents,
       count: formattedEvents.length,
+      nextPageToken,
     };
   } catch (error) {
-    logger.error("Error fetching calendar events:", error);
+    logger.error("Error fetching calendar events:", error, requestLogFields());
+    // Typed errors keep their code, and a rejected token or grant becomes reconnect-required rather than INTERNAL
+    if (error instanceof HttpsError) {
+      throw error;
+    }
+    if (isProviderAuthError(error) || isRevokedGrantError(error)) {
+      throw calendarReconnectRequiredError();
+    }
     throw new Error(`Failed to fetch calendar events: ${error instanceof Error ? error.message : "Unknown error"}`);
   }
 }
+
+// Group events into day buckets in the given time zone (all-day events keep their calendar date)
+export function groupEventsByDay(events: CalendarEvent[], timeZone: string): CalendarDay[] {
+  // en-CA formats dates as YYYY-MM-DD
+  const formatter = new Intl.DateTimeFormat("en-CA", { timeZone, year: "numeric", month: "2-digit", day: "2-digit" });
+  const days = new Map<string, CalendarEvent[]>();
+
+  events.forEach((event) => {
+    const date = event.start.date || (event.start.dateTime ? formatter.format(new Date(event.start.dateTime)) : null);
+    if (!date) {
+      return;
+    }
+    days.set(date, [...(days.get(date) || []), event]);
+  });
+
+  return Array.from(days.keys())
+    .sort()
+    .map((date) => ({ date, events: days.get(date) || [] }));
+}
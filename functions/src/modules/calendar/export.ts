@@ -0,0 +1,96 @@
+// ICS export of the user's schedule with weather annotations
+
+import { CalendarEvent, ForecastDay } from "../../types";
+
+// Helper function to escape an ICS TEXT value (RFC 5545 section 3.3.11)
+function escapeText(value: string): string {
+  return value
+    .replace(/\\/g, "\\\\")
+    .replace(/;/g, "\\;")
+    .replace(/,/g, "\\,")
+    .replace(/\r?\n/g, "\\n");
+}
+
+// Helper function to fold a content line to 75 octets, continuing with a leading space
+function foldLine(line: string): string {
+  const chunks: string[] = [];
+  let current = "";
+  let currentBytes = 0;
+
+  for (const char of line) {
+    const charBytes = Buffer.byteLength(char, "utf8");
+    const limit = chunks.length === 0 ? 75 : 74; // Continuation lines spend one octet on the space
+    if (currentBytes + charBytes > limit) {
+      chunks.push(current);
+      current = "";
+      currentBytes = 0;
+    }
+    current += char;
+    currentBytes += charBytes;
+  }
+  chunks.push(current);
+
+  return chunks.join("\r\n ");
+}
+
+// Helper function to format an event time - timed events are exported in UTC, so no VTIMEZONE is needed
+function formatIcsTime(name: string, time: { dateTime?: string | null; date?: string | null }): string | null {
+  if (time.dateTime) {
+    return `${name}:${new Date(time.dateTime).toISOString().replace(/[-:]/g, "").replace(/\.\d{3}/, "")}`;
+  }
+  if (time.date) {
+    return `${name};VALUE=DATE:${time.date.replace(/-/g, "")}`;
+  }
+  return null;
+}
+
+// Helper function to describe a forecast day for an event description
+function describeWeather(day: ForecastDay, units: string): string {
+  const unit = units === "imperial" ? "°F" : "°C";
+  return `Weather: ${day.condition}, high ${day.highTemp}${unit} / low ${day.lowTemp}${unit}, ${day.precipitation}% chance of precipitation`;
+}
+
+// Build an ICS calendar of events, appending the forecast for each event's local date when known
+export function buildWeatherAnnotatedIcs(events: CalendarEvent[], forecastDays: ForecastDay[], units: string): string {
+  const forecastByDate = new Map(forecastDays.map((day): [string, ForecastDay] => [day.date, day]));
+  const stamp = new Date().toISOString().replace(/[-:]/g, "").replace(/\.\d{3}/, "");
+
+  const lines = [
+    "BEGIN:VCALENDAR",
+    "VERSION:2.0",
+    "PRODID:-//Scott Weather Service//Weather Schedule//EN",
+    "CALSCALE:GREGORIAN",
+    "X-WR-CALNAME:Weather Schedule",
+  ];
+
+  events.forEach((event) => {
+    const start = formatIcsTime("DTSTART", event.start);
+    if (!start) {
+      return;
+    }
+
+    // Google returns offset-local dateTimes, so the first 10 characters are the event's local date
+    const eventDate = (event.start.date || event.start.dateTime || "").slice(0, 10);
+    const forecast = forecastByDate.get(eventDate);
+    const description = [event.description, forecast ? describeWeather(forecast, units) : null]
+      .filter(Boolean)
+      .join("\n\n");
+
+    const end = formatIcsTime("DTEND", event.end);
+
+    lines.push(
+      "BEGIN:VEVENT",
+      `UID:${escapeText(event.id)}@scott-weather-service`,
+      `DTSTAMP:${stamp}`,
+      start,
+      ...(end ? [end] : []),
+      `SUMMARY:${escapeText(event.summary)}`,
+      ...(event.location ? [`LOCATION:${escapeText(event.location)}`] : []),
+      ...(description ? [`DESCRIPTION:${escapeText(description)}`] : []),
+      "END:VEVENT"
+    );
+  });
+
+  lines.push("END:VCALENDAR");
+  return lines.map(foldLine).join("\r\n") + "\r\n";
+}
@@ -0,0 +1,93 @@
+// ICS parsing, recurrence and feed cache tests
+
+import { afterEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { httpClient } from "../shared/http";
+import { fetchIcsFeed, icsEventsInWindow, parseIcs } from "./ics";
+
+// Helper function to wrap VEVENT property lines in a calendar
+function calendar(...events: string[][]): string {
+  const vevents = events.reduce<string[]>((lines, event) => [...lines, "BEGIN:VEVENT", ...event, "END:VEVENT"], []);
+  return ["BEGIN:VCALENDAR", ...vevents, "END:VCALENDAR"].join("\r\n");
+}
+
+// Helper function to list the occurrence starts inside a window
+function startsInWindow(ics: string, windowStart: string, windowEnd: string): Array<string | null | undefined> {
+  return icsEventsInWindow(parseIcs(ics), new Date(windowStart), new Date(windowEnd))
+    .map((event) => event.start.dateTime ?? event.start.date);
+}
+
+test("DAILY series that started years ago still reach the window", () => {
+  const ics = calendar(["UID:daily", "DTSTART:20200101T090000Z", "DTEND:20200101T091500Z", "RRULE:FREQ=DAILY"]);
+  assert.deepEqual(startsInWindow(ics, "2026-03-10T00:00:00Z", "2026-03-12T00:00:00Z"),
+    ["2026-03-10T09:00:00.000Z", "2026-03-11T09:00:00.000Z"]);
+});
+
+test("WEEKLY BYDAY expands each listed weekday in local time across DST", () => {
+  const ics = calendar([
+    "UID:weekly",
+    "DTSTART;TZID=America/New_York:20200106T100000",
+    "DTEND;TZID=America/New_York:20200106T110000",
+    "RRULE:FREQ=WEEKLY;BYDAY=MO,WE",
+  ]);
+  // US daylight saving starts on 8 March 2026, so 10:00 local moves from 15:00Z to 14:00Z
+  assert.deepEqual(startsInWindow(ics, "2026-03-02T00:00:00Z", "2026-03-12T00:00:00Z"),
+    ["2026-03-02T15:00:00.000Z", "2026-03-04T15:00:00.000Z", "2026-03-09T14:00:00.000Z", "2026-03-11T14:00:00.000Z"]);
+});
+
+test("a long occurrence that began before the window is still included", () => {
+  const ics = calendar(["UID:long", "DTSTART:20200101T000000Z", "DTEND:20200111T000000Z", "RRULE:FREQ=WEEKLY;INTERVAL=2"]);
+  assert.deepEqual(startsInWindow(ics, "2026-03-10T00:00:00Z", "2026-03-11T00:00:00Z"), ["2026-03-04T00:00:00.000Z"]);
+});
+
+test("COUNT stops the series after that many occurrences", () => {
+  const ics = calendar(["UID:count", "DTSTART:20260101T120000Z", "RRULE:FREQ=DAILY;COUNT=3"]);
+  assert.deepEqual(startsInWindow(ics, "2026-01-01T00:00:00Z", "2026-02-01T00:00:00Z"),
+    ["2026-01-01T12:00:00.000Z", "2026-01-02T12:00:00.000Z", "2026-01-03T12:00:00.000Z"]);
+});
+
+test("UNTIL is inclusive and ends the series", () => {
+  const ics = calendar(["UID:until", "DTSTART:20200106T080000Z", "RRULE:FREQ=WEEKLY;UNTIL=20260316T080000Z"]);
+  assert.deepEqual(startsInWindow(ics, "2026-03-01T00:00:00Z", "2026-04-01T00:00:00Z"),
+    ["2026-03-02T08:00:00.000Z", "2026-03-09T08:00:00.000Z", "2026-03-16T08:00:00.000Z"]);
+});
+
+test("EXDATE removes single occurrences", () => {
+  const ics = calendar([
+    "UID:exdate",
+    "DTSTART:20260101T120000Z",
+    "RRULE:FREQ=DAILY;COUNT=4",
+    "EXDATE:20260102T120000Z,20260104T120000Z",
+  ]);
+  assert.deepEqual(startsInWindow(ics, "2026-01-01T00:00:00Z", "2026-02-01T00:00:00Z"),
+    ["2026-01-01T12:00:00.000Z", "2026-01-03T12:00:00.000Z"]);
+});
+
+test("all-day events keep date-only starts and span the whole day", () => {
+  const ics = calendar(["UID:allday", "SUMMARY:Holiday", "DTSTART;VALUE=DATE:19000704", "RRULE:FREQ=YEARLY"]);
+  const [event, ...rest] = icsEventsInWindow(parseIcs(ics), new Date("2026-01-01T00:00:00Z"), new Date("2027-01-01T00:00:00Z"));
+  assert.equal(rest.length, 0);
+  assert.deepEqual(event.start, { date: "2026-07-04" });
+  assert.deepEqual(event.end, { date: "2026-07-05" });
+  assert.equal(event.summary, "Holiday");
+});
+
+const originalGet = httpClient.get;
+afterEach(() => {
+  httpClient.get = originalGet;
+});
+
+test("a 304 reuses the cached feed body and sends the validator", async () => {
+  const sentHeaders: Array<{ [name: string]: string }> = [];
+  httpClient.get = (async (_url: string, config: { headers: { [name: string]: string } }) => {
+    sentHeaders.push(config.headers);
+    return sentHeaders.length === 1
+      ? { status: 200, data: "BEGIN:VCALENDAR\r\nEND:VCALENDAR", headers: { etag: "\"v1\"" } }
+      : { status: 304, data: "", headers: {} };
+  }) as unknown as typeof httpClient.get;
+
+  const url = "https://calendar.example.com/feed.ics";
+  assert.equal(await fetchIcsFeed(url), "BEGIN:VCALENDAR\r\nEND:VCALENDAR");
+  assert.equal(await fetchIcsFeed(url), "BEGIN:VCALENDAR\r\nEND:VCALENDAR");
+  assert.deepEqual(sentHeaders, [{}, { "If-None-Match": "\"v1\"" }]);
+});
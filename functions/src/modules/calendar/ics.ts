@@ -0,0 +1,429 @@
+// iCalendar (ICS) feed parsing and recurrence expansion
+
+import * as logger from "firebase-functions/logger";
+import { CalendarEvent } from "../../types";
+import { httpClient } from "../shared/http";
+import { userUrlRequestConfig } from "../shared/ssrf";
+import { CALENDAR_CONFIG, HTTP_CONFIG } from "../../config";
+
+interface IcsProperty {
+  params: { [name: string]: string };
+  value: string;
+}
+
+interface IcsTime {
+  wall: Date; // Wall-clock fields stored in the Date's UTC fields
+  allDay: boolean;
+  utc: boolean;
+  timeZone?: string;
+}
+
+interface IcsEvent {
+  uid: string;
+  summary: string;
+  location: string | null;
+  description: string | null;
+  start: IcsTime;
+  end: IcsTime | null;
+  rrule: string | null;
+  exdates: number[];
+  recurrenceId: number | null;
+}
+
+const DAY_MS = 24 * 60 * 60 * 1000;
+
+// Upper bound on recurrence periods examined per event, so a bad RRULE can't spin forever
+const MAX_RECURRENCE_PERIODS = 5000;
+
+const WEEKDAYS = ["SU", "MO", "TU", "WE", "TH", "FR", "SA"];
+
+// Normalize a user-supplied feed URL (webcal:// is just https:// for subscriptions)
+export function normalizeIcsUrl(rawUrl: string): string {
+  const url = new URL(rawUrl.replace(/^webcals?:\/\//i, "https://"));
+  if (url.protocol !== "https:" && url.protocol !== "http:") {
+    throw new Error(`Unsupported ICS URL scheme: ${url.protocol}`);
+  }
+  return url.toString();
+}
+
+// Helper function to unescape an ICS TEXT value
+function unescapeText(value: string): string {
+  return value
+    .replace(/\\[nN]/g, "\n")
+    .replace(/\\([,;\\])/g, "$1");
+}
+
+// Helper function to get a zone's UTC offset (ms) at an instant
+function getTimeZoneOffset(instant: Date, timeZone: string): number {
+  const parts = new Intl.DateTimeFormat("en-US", {
+    timeZone,
+    hour12: false,
+    year: "numeric",
+    month: "2-digit",
+    day: "2-digit",
+    hour: "2-digit",
+    minute: "2-digit",
+    second: "2-digit",
+  }).formatToParts(instant);
+  const get = (type: string) => Number(parts.find((part) => part.type === type)?.value);
+
+  const asUtc = Date.UTC(get("year"), get("month") - 1, get("day"), get("hour") % 24, get("minute"), get("second"));
+  return asUtc - Math.floor(instant.getTime() / 1000) * 1000;
+}
+
+// Helper function to convert an ICS time to an absolute instant
+function toInstant(time: IcsTime): Date {
+  if (time.allDay || time.utc || !time.timeZone) {
+    // All-day and floating times are treated as UTC
+    return time.wall;
+  }
+
+  try {
+    // Correct the naive guess by the zone offset, re-checking once across DST transitions
+    const guess = time.wall.getTime();
+    const offset = getTimeZoneOffset(time.wall, time.timeZone);
+    const corrected = getTimeZoneOffset(new Date(guess - offset), time.timeZone);
+    return new Date(guess - corrected);
+  } catch {
+    // Non-IANA TZIDs (e.g. Windows zone names) aren't understood by Intl
+    logger.warn(`Unknown ICS time zone ${time.timeZone}, treating as UTC`);
+    return time.wall;
+  }
+}
+
+// Helper function to parse an ICS DATE or DATE-TIME value
+function parseIcsTime(property: IcsProperty): IcsTime | null {
+  const match = property.value.trim().match(/^(\d{4})(\d{2})(\d{2})(?:T(\d{2})(\d{2})(\d{2})(Z)?)?$/);
+  if (!match) {
+    return null;
+  }
+
+  const [, year, month, day, hour, minute, second, zulu] = match;
+  return {
+    wall: new Date(Date.UTC(Number(year), Number(month) - 1, Number(day), Number(hour || 0), Number(minute || 0), Number(second || 0))),
+    allDay: !hour || property.params.VALUE === "DATE",
+    utc: !!zulu,
+    timeZone: zulu ? undefined : property.params.TZID,
+  };
+}
+
+// Helper function to split a content line into name, parameters, and value
+function parseContentLine(line: string): { name: string; property: IcsProperty } | null {
+  // The value starts at the first colon outside a quoted parameter value
+  let colon = -1;
+  let quoted = false;
+  for (let i = 0; i < line.length && colon < 0; i++) {
+    if (line[i] === "\"") {
+      quoted = !quoted;
+    } else if (line[i] === ":" && !quoted) {
+      colon = i;
+    }
+  }
+
+  if (colon < 0) {
+    return null;
+  }
+
+  const [name, ...paramParts] = line.slice(0, colon).split(";");
+  const params: { [name: string]: string } = {};
+  paramParts.forEach((part) => {
+    const [key, ...rest] = part.split("=");
+    params[key.toUpperCase()] = rest.join("=").replace(/^"|"$/g, "");
+  });
+
+  return { name: name.toUpperCase(), property: { params, value: line.slice(colon + 1) } };
+}
+
+// Parse the VEVENTs out of an ICS document
+export function parseIcs(text: string): IcsEvent[] {
+  // Unfold continuation lines (RFC 5545 section 3.1)
+  const lines = text.replace(/\r?\n[ \t]/g, "").split(/\r?\n/);
+  const events: IcsEvent[] = [];
+  let props: { [name: string]: IcsProperty[] } | null = null;
+
+  for (const line of lines) {
+    if (line === "BEGIN:VEVENT") {
+      props = {};
+    } else if (line === "END:VEVENT" && props) {
+      const event = buildEvent(props);
+      if (event) {
+        events.push(event);
+      }
+      props = null;
+    } else if (props) {
+      const parsed = parseContentLine(line);
+      if (parsed) {
+        props[parsed.name] = [...(props[parsed.name] || []), parsed.property];
+      }
+    }
+  }
+
+  return events;
+}
+
+// Helper function to build an event from its VEVENT properties
+function buildEvent(props: { [name: string]: IcsProperty[] }): IcsEvent | null {
+  const start = props.DTSTART ? parseIcsTime(props.DTSTART[0]) : null;
+  if (!start) {
+    return null; // An event without a start can't be placed on a calendar
+  }
+
+  const exdates: number[] = [];
+  (props.EXDATE || []).forEach((exdate) => {
+    exdate.value.split(",").forEach((value) => {
+      const time = parseIcsTime({ params: exdate.params, value });
+      if (time) {
+        exdates.push(toInstant(time).getTime());
+      }
+    });
+  });
+
+  const recurrence = props["RECURRENCE-ID"] ? parseIcsTime(props["RECURRENCE-ID"][0]) : null;
+
+  return {
+    uid: props.UID ? props.UID[0].value : `${start.wall.getTime()}`,
+    summary: props.SUMMARY ? unescapeText(props.SUMMARY[0].value) : "No title",
+    location: props.LOCATION ? unescapeText(props.LOCATION[0].value) : null,
+    description: props.DESCRIPTION ? unescapeText(props.DESCRIPTION[0].value) : null,
+    start,
+    end: props.DTEND ? parseIcsTime(props.DTEND[0]) : null,
+    rrule: props.RRULE ? props.RRULE[0].value : null,
+    exdates,
+    recurrenceId: recurrence ? toInstant(recurrence).getTime() : null,
+  };
+}
+
+// Helper function to list candidate wall-clock starts for one recurrence period
+function periodCandidates(base: Date, freq: string, step: number, byDay: number[]): Date[] {
+  const candidate = new Date(base.getTime());
+
+  switch (freq) {
+  case "DAILY":
+    candidate.setUTCDate(candidate.getUTCDate() + step);
+    return [candidate];
+  case "WEEKLY": {
+    candidate.setUTCDate(candidate.getUTCDate() + step * 7);
+    if (byDay.length === 0) {
+      return [candidate];
+    }
+    // Weeks start on Monday (the RFC 5545 default WKST)
+    const weekStart = new Date(candidate.getTime());
+    weekStart.setUTCDate(weekStart.getUTCDate() - ((weekStart.getUTCDay() + 6) % 7));
+    return byDay
+      .map((weekday) => {
+        const day = new Date(weekStart.getTime());
+        day.setUTCDate(day.getUTCDate() + ((weekday + 6) % 7));
+        return day;
+      })
+      .sort((a, b) => a.getTime() - b.getTime());
+  }
+  case "MONTHLY":
+    candidate.setUTCMonth(candidate.getUTCMonth() + step);
+    // Months without the start's day (e.g. the 31st) are skipped, per the RFC
+    return candidate.getUTCDate() === base.getUTCDate() ? [candidate] : [];
+  case "YEARLY":
+    candidate.setUTCFullYear(candidate.getUTCFullYear() + step);
+    return candidate.getUTCMonth() === base.getUTCMonth() ? [candidate] : [];
+  default:
+    return step === 0 ? [candidate] : [];
+  }
+}
+
+// Helper function to find the first recurrence period that can reach the window, so long-running series
+// aren't walked period by period from their first occurrence. Errs early: `earliest` already allows for the
+// occurrence length, and the caller's candidate checks drop anything still before the window
+function firstRelevantPeriod(base: Date, freq: string, interval: number, earliest: number): number {
+  // A week of slack covers BYDAY days falling before the period's anchor, and zone offsets
+  const target = new Date(earliest - 8 * DAY_MS);
+  if (target.getTime() <= base.getTime()) {
+    return 0;
+  }
+
+  let periods: number;
+  switch (freq) {
+  case "DAILY":
+    periods = (target.getTime() - base.getTime()) / DAY_MS;
+    break;
+  case "WEEKLY":
+    periods = (target.getTime() - base.getTime()) / (7 * DAY_MS);
+    break;
+  case "MONTHLY":
+    periods = (target.getUTCFullYear() - base.getUTCFullYear()) * 12 + (target.getUTCMonth() - base.getUTCMonth()) - 1;
+    break;
+  case "YEARLY":
+    periods = target.getUTCFullYear() - base.getUTCFullYear() - 1;
+    break;
+  default:
+    return 0;
+  }
+
+  return Math.max(0, Math.floor(periods / interval));
+}
+
+// Expand an event into the occurrence start instants that overlap the window
+function expandOccurrences(event: IcsEvent, durationMs: number, windowStart: Date, windowEnd: Date): Date[] {
+  const firstStart = toInstant(event.start);
+  const overlaps = (start: Date) =>
+    start.getTime() < windowEnd.getTime() && start.getTime() + durationMs > windowStart.getTime();
+
+  if (!event.rrule) {
+    return overlaps(firstStart) ? [firstStart] : [];
+  }
+
+  const rule: { [key: string]: string } = {};
+  event.rrule.split(";").forEach((part) => {
+    const [key, value] = part.split("=");
+    rule[key.toUpperCase()] = value;
+  });
+
+  const interval = Math.max(1, Number(rule.INTERVAL) || 1);
+  const count = rule.COUNT ? Number(rule.COUNT) : Infinity;
+  const untilTime = rule.UNTIL ? parseIcsTime({ params: {}, value: rule.UNTIL }) : null;
+  const until = untilTime ? toInstant(untilTime).getTime() : Infinity;
+  const byDay = (rule.BYDAY || "")
+    .split(",")
+    .map((day) => WEEKDAYS.indexOf(day.slice(-2)))
+    .filter((day) => day >= 0);
+
+  const occurrences: Date[] = [];
+  let seen = 0;
+
+  // COUNT is counted from the first occurrence, so only open-ended and UNTIL series can skip ahead.
+  // Counted series are bounded by their COUNT (and MAX_RECURRENCE_PERIODS) anyway
+  const firstPeriod = count === Infinity
+    ? firstRelevantPeriod(event.start.wall, rule.FREQ, interval, windowStart.getTime() - durationMs)
+    : 0;
+
+  for (let period = firstPeriod; period < firstPeriod + MAX_RECURRENCE_PERIODS; period++) {
+    for (const wall of periodCandidates(event.start.wall, rule.FREQ, period * interval, byDay)) {
+      if (wall.getTime() < event.start.wall.getTime()) {
+        continue;
+      }
+
+      const start = toInstant({ ...event.start, wall });
+      seen++;
+      if (seen > count || start.getTime() > until || start.getTime() >= windowEnd.getTime()) {
+        return occurrences;
+      }
+
+      if (overlaps(start) && !event.exdates.includes(start.getTime())) {
+        occurrences.push(start);
+      }
+    }
+  }
+
+  return occurrences;
+}
+
+// Helper function to format an occurrence for our CalendarEvent model
+function formatTime(instant: Date, allDay: boolean): { dateTime?: string | null; date?: string | null } {
+  return allDay ? { date: instant.toISOString().split("T")[0] } : { dateTime: instant.toISOString() };
+}
+
+// Convert parsed events into CalendarEvents within the window, ordered by start
+export function icsEventsInWindow(events: IcsEvent[], windowStart: Date, windowEnd: Date): CalendarEvent[] {
+  // Modified instances (RECURRENCE-ID) replace the master's occurrence at that time
+  const overridden = new Map<string, number[]>();
+  events.filter((event) => event.recurrenceId !== null).forEach((event) => {
+    overridden.set(event.uid, [...(overridden.get(event.uid) || []), event.recurrenceId as number]);
+  });
+
+  const results: { start: Date; event: CalendarEvent }[] = [];
+
+  events.forEach((event) => {
+    const start = toInstant(event.start);
+    const durationMs = event.end
+      ? Math.max(0, toInstant(event.end).getTime() - start.getTime())
+      : (event.start.allDay ? DAY_MS : 0);
+
+    const master = event.recurrenceId === null
+      ? { ...event, exdates: [...event.exdates, ...(overridden.get(event.uid) || [])] }
+      : { ...event, rrule: null };
+
+    expandOccurrences(master, durationMs, windowStart, windowEnd).forEach((occurrence) => {
+      results.push({
+        start: occurrence,
+        event: {
+          id: master.rrule || event.recurrenceId !== null
+            ? `${event.uid}_${new Date(event.recurrenceId ?? occurrence.getTime()).toISOString()}`
+            : event.uid,
+          summary: event.summary,
+          start: formatTime(occurrence, event.start.allDay),
+          end: formatTime(new Date(occurrence.getTime() + durationMs), event.start.allDay),
+          location: event.location,
+          description: event.description,
+        },
+      });
+    });
+  });
+
+  return results
+    .sort((a, b) => a.start.getTime() - b.start.getTime())
+    .map((result) => result.event);
+}
+
+// Per-instance feed cache for conditional requests (ETag / Last-Modified). Map order doubles as recency order:
+// hits are moved to the end and the oldest entries are evicted once the bodies outgrow ICS_FEED_CACHE_BYTES
+const feedCache = new Map<string, { body: string; etag?: string; lastModified?: string }>();
+let feedCacheBytes = 0;
+
+// Helper function to drop a feed from the cache, keeping the byte total in step
+function forgetIcsFeed(feedUrl: string): void {
+  const entry = feedCache.get(feedUrl);
+  if (entry) {
+    feedCacheBytes -= entry.body.length;
+    feedCache.delete(feedUrl);
+  }
+}
+
+// Helper function to cache a feed body, evicting least recently used feeds to stay under the byte cap
+function rememberIcsFeed(feedUrl: string, entry: { body: string; etag?: string; lastModified?: string }): void {
+  forgetIcsFeed(feedUrl);
+  if (!entry.etag && !entry.lastModified) {
+    return; // Nothing to revalidate with, so the body would never be reused
+  }
+  if (entry.body.length > CALENDAR_CONFIG.ICS_FEED_CACHE_BYTES) {
+    return;
+  }
+
+  while (feedCacheBytes + entry.body.length > CALENDAR_CONFIG.ICS_FEED_CACHE_BYTES) {
+    const oldest = feedCache.keys().next().value as string;
+    forgetIcsFeed(oldest);
+  }
+  feedCache.set(feedUrl, entry);
+  feedCacheBytes += entry.body.length;
+}
+
+// Fetch an ICS feed, reusing the cached body when the server answers 304
+export async function fetchIcsFeed(feedUrl: string): Promise<string> {
+  const cached = feedCache.get(feedUrl);
+  const headers: { [name: string]: string } = {};
+  if (cached?.etag) headers["If-None-Match"] = cached.etag;
+  if (cached?.lastModified) headers["If-Modified-Since"] = cached.lastModified;
+
+  const response = await httpClient.get<string>(feedUrl, {
+    ...userUrlRequestConfig,
+    headers,
+    responseType: "text",
+    maxContentLength: HTTP_CONFIG.MAX_ICS_RESPONSE_BYTES, // Whole-calendar exports run larger than API responses
+    validateStatus: (status) => (status >= 200 && status < 300) || status === 304,
+  });
+
+  if (response.status === 304 && cached) {
+    logger.info(`ICS feed not modified: ${new URL(feedUrl).host}`);
+    // Re-insert so a feed that's still in use counts as recently used
+    feedCache.delete(feedUrl);
+    feedCache.set(feedUrl, cached);
+    return cached.body;
+  }
+
+  const body = response.data;
+  const etag = response.headers["etag"];
+  const lastModified = response.headers["last-modified"];
+  rememberIcsFeed(feedUrl, {
+    body,
+    etag: typeof etag === "string" ? etag : undefined,
+    lastModified: typeof lastModified === "string" ? lastModified : undefined,
+  });
+  return body;
+}
@@ -2,3 +2,12 @@
 
 export * from "./events";
 export * from "./auth";
+export * from "./providers";
+export * from "./ics";
+export * from "./export";
+export * from "./sweeper";
+export * from "./next";
+export * from "./errors";
+export * from "./oauth";
+export * from "./search";
+export * from "./eventWeather";
@@ -0,0 +1,47 @@
+// Next upcoming event with weather, for glanceable widgets
+
+import * as logger from "firebase-functions/logger";
+import { NextEventRequest, NextEventResponse, ForecastDay } from "../../types";
+import { getWeatherForecast } from "../weather";
+import { getCalendarEventsWithAuth } from "./auth";
+
+// Look ahead no further than the forecast covers
+const NEXT_EVENT_WINDOW_MS = 5 * 24 * 60 * 60 * 1000;
+
+// Get the user's next upcoming event, enriched with the forecast for its day when a location is given
+export async function getNextCalendarEvent(userId: string, request: NextEventRequest): Promise<NextEventResponse> {
+  const now = new Date();
+  const { events } = await getCalendarEventsWithAuth(userId, {
+    timeMin: now.toISOString(),
+    timeMax: new Date(now.getTime() + NEXT_EVENT_WINDOW_MS).toISOString(),
+    maxResults: 10,
+  });
+
+  // timeMin also matches events already in progress, so skip anything that has started
+  const event = events.find((candidate) => {
+    const start = candidate.start.dateTime || candidate.start.date;
+    return start && new Date(start).getTime() >= now.getTime();
+  });
+
+  if (!event) {
+    return { success: true, event: null, weather: null };
+  }
+
+  let weather: ForecastDay | null = null;
+  if (request.latitude !== undefined && request.longitude !== undefined) {
+    try {
+      const forecast = await getWeatherForecast({
+        latitude: request.latitude,
+        longitude: request.longitude,
+        units: request.units,
+      });
+      // Google returns offset-local dateTimes, so the first 10 characters are the event's local date
+      const eventDate = (event.start.date || event.start.dateTime || "").slice(0, 10);
+      weather = forecast.data.days.find((day) => day.date === eventDate) || null;
+    } catch (error) {
+      logger.warn("Returning next event without weather:", error);
+    }
+  }
+
+  return { success: true, event, weather };
+}
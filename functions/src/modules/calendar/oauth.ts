@@ -0,0 +1,38 @@
+// Google OAuth client construction - one place for credentials so the exchange and refresh paths can't drift
+
+import { google } from "googleapis";
+import { googleClientId, googleClientSecret, CALENDAR_CONFIG, HTTP_CONFIG } from "../../config";
+import { httpsAgent } from "../shared/http";
+
+// Scopes the calendar integration requests (the frontend's consent URL must ask for the same)
+export const GOOGLE_CALENDAR_SCOPES = ["https://www.googleapis.com/auth/calendar.readonly"];
+
+// Check a granted scope string - users can untick scopes on Google's granular consent screen
+export function hasGoogleCalendarScopes(grantedScope: string | null | undefined): boolean {
+  const granted = (grantedScope || "").split(" ");
+  return GOOGLE_CALENDAR_SCOPES.every((scope) => granted.includes(scope));
+}
+
+// Build a Google OAuth2 client from the configured credentials. Code exchange needs the redirect URI the
+// authorization used; refresh-only clients can omit it. Token calls go through the shared agent, so the
+// custom CA bundle and keep-alive apply to Google's token endpoint too, and are bounded by the Google timeout.
+// Refreshes are retried on network errors and 5xx like our other clients; a code exchange is never repeated,
+// since Google burns the code on the first attempt even if the response is lost
+export function createGoogleOAuthClient(redirectUri?: string) {
+  return new google.auth.OAuth2({
+    clientId: googleClientId.value(),
+    clientSecret: googleClientSecret.value(),
+    redirectUri,
+    transporterOptions: {
+      agent: httpsAgent,
+      timeout: CALENDAR_CONFIG.GOOGLE_TIMEOUT_MS,
+      retry: !redirectUri,
+      retryConfig: {
+        retry: HTTP_CONFIG.MAX_RETRIES,
+        retryDelay: HTTP_CONFIG.RETRY_BASE_DELAY_MS,
+        httpMethodsToRetry: ["GET", "HEAD", "POST"],
+        statusCodesToRetry: [[500, 599]],
+      },
+    },
+  });
+}
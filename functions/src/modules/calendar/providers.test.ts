@@ -0,0 +1,121 @@
+// Microsoft Graph provider tests - the Graph client is stubbed
+
+import { afterEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { CALENDAR_CONFIG } from "../../config";
+import { graphHttpClient, microsoftCalendarProvider } from "./providers";
+
+const originalGet = graphHttpClient.get;
+afterEach(() => {
+  graphHttpClient.get = originalGet;
+});
+
+// Helper function to answer Graph requests from a list of pages, recording the URLs asked for
+function stubGraph(pages: Array<{ value: unknown[]; "@odata.nextLink"?: string }>): string[] {
+  const requested: string[] = [];
+  graphHttpClient.get = (async (url: string) => {
+    requested.push(url);
+    return { status: 200, data: pages[requested.length - 1], headers: {} };
+  }) as unknown as typeof graphHttpClient.get;
+  return requested;
+}
+
+const graphEvent = (id: string) => ({
+  id,
+  subject: `Event ${id}`,
+  start: { dateTime: "2026-03-10T09:00:00.0000000", timeZone: "UTC" },
+  end: { dateTime: "2026-03-10T10:00:00.0000000", timeZone: "UTC" },
+});
+
+test("the Graph client is bounded by the Microsoft timeout", () => {
+  assert.equal(graphHttpClient.defaults.timeout, CALENDAR_CONFIG.MICROSOFT_TIMEOUT_MS);
+});
+
+test("Graph pages are followed through the opaque page token", async () => {
+  const nextLink = "https://graph.microsoft.com/v1.0/me/calendarView?$skiptoken=abc";
+  const requested = stubGraph([
+    { value: [graphEvent("1")], "@odata.nextLink": nextLink },
+    { value: [graphEvent("2")] },
+  ]);
+
+  const options = { calendarId: "primary", maxResults: 1, timeMin: "2026-03-10T00:00:00Z", timeMax: "2026-03-11T00:00:00Z" };
+  const first = await microsoftCalendarProvider.listEvents("graph-token", options);
+  assert.deepEqual(first.events.map((event) => event.id), ["1"]);
+  assert.equal(first.events[0].start.dateTime, "2026-03-10T09:00:00.0000000Z");
+  assert.ok(first.nextPageToken);
+
+  const second = await microsoftCalendarProvider.listEvents("graph-token", { ...options, pageToken: first.nextPageToken as string });
+  assert.deepEqual(second.events.map((event) => event.id), ["2"]);
+  assert.equal(second.nextPageToken, null);
+  assert.deepEqual(requested, ["https://graph.microsoft.com/v1.0/me/calendarView", nextLink]);
+});
+
+test("page tokens that don't point at Graph are rejected before any request", async () => {
+  const requested = stubGraph([]);
+  const tokens = [
+    "https://evil.example.com/v1.0/me/calendarView",
+    "https://graph.microsoft.com.evil.example.com/v1.0/me/calendarView",
+    "http://graph.microsoft.com/v1.0/me/calendarView",
+    "not a url",
+  ].map((link) => Buffer.from(link, "utf8").toString("base64url"));
+
+  for (const pageToken of tokens) {
+    await assert.rejects(
+      microsoftCalendarProvider.listEvents("graph-token", { calendarId: "primary", maxResults: 10, pageToken }),
+      /Invalid calendar page token/
+    );
+  }
+  assert.deepEqual(requested, []);
+});
+
+// Helper function to answer Graph requests like stubGraph, also recording each request's query parameters
+function stubGraphWithParams(pages: Array<{ value: unknown[]; "@odata.nextLink"?: string }>) {
+  const requested: Array<{ url: string; params?: { [name: string]: unknown } }> = [];
+  graphHttpClient.get = (async (url: string, config?: { params?: { [name: string]: unknown } }) => {
+    requested.push({ url, params: config?.params });
+    return { status: 200, data: pages[requested.length - 1], headers: {} };
+  }) as unknown as typeof graphHttpClient.get;
+  return requested;
+}
+
+const matching = (id: string, subject: string, extra: object = {}) => ({ ...graphEvent(id), subject, ...extra });
+
+test("keyword searches send no $filter or $search and match title, notes and location here", async () => {
+  const nextLink = "https://graph.microsoft.com/v1.0/me/calendarView?$skiptoken=page2";
+  const requested = stubGraphWithParams([
+    { value: [matching("1", "Dentist"), matching("2", "Team sync"), matching("3", "Lunch", { bodyPreview: "bring the dentist forms" })], "@odata.nextLink": nextLink },
+    { value: [matching("4", "Errands", { location: { displayName: "Dentist's office" } }), matching("5", "Gym")] },
+  ]);
+
+  const page = await microsoftCalendarProvider.listEvents("graph-token", {
+    calendarId: "primary", maxResults: 10, timeMin: "2026-03-01T00:00:00Z", timeMax: "2026-04-01T00:00:00Z", q: "dentist",
+  });
+  assert.deepEqual(page.events.map((event) => event.id), ["1", "3", "4"]);
+  assert.equal(page.nextPageToken, null);
+
+  assert.deepEqual(requested.map((request) => request.url), ["https://graph.microsoft.com/v1.0/me/calendarView", nextLink]);
+  assert.deepEqual(requested[0].params, {
+    startDateTime: "2026-03-01T00:00:00Z",
+    endDateTime: "2026-04-01T00:00:00Z",
+    $orderby: "start/dateTime",
+    $select: "id,subject,isAllDay,start,end,location,bodyPreview",
+    $top: 100,
+  });
+});
+
+test("keyword search results page by offset into the matches", async () => {
+  const events = [matching("1", "Standup"), matching("2", "Review"), matching("3", "Standup"), matching("4", "Standup")];
+  const options = { calendarId: "primary", maxResults: 2, timeMin: "2026-03-01T00:00:00Z", timeMax: "2026-03-08T00:00:00Z", q: "standup" };
+
+  stubGraphWithParams([{ value: events }]);
+  const first = await microsoftCalendarProvider.listEvents("graph-token", options);
+  assert.deepEqual(first.events.map((event) => event.id), ["1", "3"]);
+  assert.equal(first.nextPageToken, "2");
+
+  stubGraphWithParams([{ value: events }]);
+  const second = await microsoftCalendarProvider.listEvents("graph-token", { ...options, pageToken: first.nextPageToken as string });
+  assert.deepEqual(second.events.map((event) => event.id), ["4"]);
+  assert.equal(second.nextPageToken, null);
+
+  await assert.rejects(microsoftCalendarProvider.listEvents("graph-token", { ...options, pageToken: "-1" }), /Invalid calendar page token/);
+});
@@ -0,0 +1,240 @@
+// Calendar providers - each lists events from its API into our CalendarEvent model
+
+import { google } from "googleapis";
+import { CalendarEvent, CalendarProviderId, MicrosoftGraphEvent } from "../../types";
+import { createHttpClient, httpsAgent } from "../shared/http";
+import { fetchIcsFeed, icsEventsInWindow, parseIcs } from "./ics";
+import { CALENDAR_CONFIG, HTTP_CONFIG } from "../../config";
+
+export interface CalendarListOptions {
+  calendarId: string;
+  maxResults: number;
+  timeMin?: string;
+  timeMax?: string;
+  pageToken?: string; // Opaque token from a previous page's nextPageToken
+  q?: string; // Keyword search; each provider matches at least the title
+}
+
+export interface CalendarEventPage {
+  events: CalendarEvent[];
+  nextPageToken: string | null; // null on the last page
+}
+
+export interface CalendarProvider {
+  listEvents(accessToken: string, options: CalendarListOptions): Promise<CalendarEventPage>;
+}
+
+// Google Calendar API
+export const googleCalendarProvider: CalendarProvider = {
+  async listEvents(accessToken, { calendarId, maxResults, timeMin, timeMax, pageToken, q }) {
+    const auth = new google.auth.OAuth2();
+    auth.setCredentials({ access_token: accessToken });
+
+    // Bounded so a slow Google API can't hold the function open until its own timeout; retries follow the shared HTTP policy
+    const calendar = google.calendar({
+      version: "v3",
+      auth,
+      agent: httpsAgent,
+      timeout: CALENDAR_CONFIG.GOOGLE_TIMEOUT_MS,
+      retry: HTTP_CONFIG.MAX_RETRIES > 0,
+      retryConfig: { retry: HTTP_CONFIG.MAX_RETRIES },
+    });
+
+    // Prepare parameters
+    const params: {
+      calendarId: string;
+      maxResults: number;
+      singleEvents: boolean;
+      orderBy: string;
+      timeMin?: string;
+      timeMax?: string;
+      pageToken?: string;
+      q?: string;
+    } = {
+      calendarId,
+      maxResults,
+      singleEvents: true,
+      orderBy: "startTime",
+    };
+
+    if (timeMin) params.timeMin = timeMin;
+    if (timeMax) params.timeMax = timeMax;
+    if (pageToken) params.pageToken = pageToken;
+    if (q) params.q = q; // Google matches title, description, location and attendees
+
+    const response = await calendar.events.list(params);
+    const events = response.data.items || [];
+
+    const formatted = events.map((event) => ({
+      id: event.id || "",
+      summary: event.summary || "No title",
+      start: {
+        dateTime: event.start?.dateTime,
+        date: event.start?.date,
+      },
+      end: {
+        dateTime: event.end?.dateTime,
+        date: event.end?.date,
+      },
+      location: event.location,
+      description: event.description,
+    }));
+
+    return { events: formatted, nextPageToken: response.data.nextPageToken || null };
+  },
+};
+
+// Default window when the caller doesn't bound it (Graph and ICS expansion need both ends)
+const DEFAULT_WINDOW_MS = 30 * 24 * 60 * 60 * 1000;
+
+// Helper function to convert a Graph date/time (returned in UTC via the Prefer header) to ours
+function toCalendarTime(value: { dateTime: string }, isAllDay: boolean): { dateTime?: string | null; date?: string | null } {
+  if (isAllDay) {
+    return { date: value.dateTime.split("T")[0] };
+  }
+  return { dateTime: value.dateTime.endsWith("Z") ? value.dateTime : `${value.dateTime}Z` };
+}
+
+const GRAPH_ORIGIN = "https://graph.microsoft.com";
+
+// Graph calls get the same bound as Google's; retries follow the shared HTTP policy
+export const graphHttpClient = createHttpClient({ timeout: CALENDAR_CONFIG.MICROSOFT_TIMEOUT_MS });
+
+// Helper function to decode a Graph page token back into its @odata.nextLink. The link carries the bearer
+// token's next request, so anything that doesn't point at Graph itself is rejected
+function decodeGraphPageToken(pageToken: string): string {
+  const nextLink = Buffer.from(pageToken, "base64url").toString("utf8");
+  let url: URL;
+  try {
+    url = new URL(nextLink);
+  } catch {
+    throw new Error("Invalid calendar page token");
+  }
+  if (url.origin !== GRAPH_ORIGIN) {
+    throw new Error("Invalid calendar page token");
+  }
+  return nextLink;
+}
+
+// Helper function to map a Graph calendarView page to ours
+function toGraphEventPage(data: { value?: MicrosoftGraphEvent[]; "@odata.nextLink"?: string }): CalendarEventPage {
+  const events = (data.value || []).map((event) => ({
+    id: event.id,
+    summary: event.subject || "No title",
+    start: toCalendarTime(event.start, !!event.isAllDay),
+    end: toCalendarTime(event.end, !!event.isAllDay),
+    location: event.location?.displayName || null,
+    description: event.bodyPreview || null,
+  }));
+  const nextLink = data["@odata.nextLink"];
+  return { events, nextPageToken: nextLink ? Buffer.from(nextLink, "utf8").toString("base64url") : null };
+}
+
+// Helper function to match an event against a keyword search, case-insensitively, like Google's q
+function matchesQuery(event: CalendarEvent, q: string): boolean {
+  const needle = q.toLowerCase();
+  return [event.summary, event.description, event.location].some((field) => !!field && field.toLowerCase().includes(needle));
+}
+
+// Keyword searches scan the window's calendarView pages, this many events per page and at most this many pages
+const GRAPH_SEARCH_PAGE_SIZE = 100;
+const GRAPH_SEARCH_MAX_PAGES = 20;
+
+// Helper function to run a keyword search over a calendarView window. calendarView doesn't reliably support
+// $filter=contains or $search, so every page in the window is fetched and matched here, as ICS does, and the
+// page token is an offset into the matches
+async function searchGraphCalendarView(
+  url: string,
+  headers: { [name: string]: string },
+  params: { [name: string]: string | number },
+  { maxResults, pageToken, q }: { maxResults: number; pageToken?: string; q: string }
+): Promise<CalendarEventPage> {
+  const offset = pageToken ? Number(pageToken) : 0;
+  if (!Number.isInteger(offset) || offset < 0) {
+    throw new Error("Invalid calendar page token");
+  }
+
+  let response = await graphHttpClient.get(url, { headers, params: { ...params, $top: GRAPH_SEARCH_PAGE_SIZE } });
+  const inWindow = toGraphEventPage(response.data).events;
+  for (let pages = 1; response.data["@odata.nextLink"] && pages < GRAPH_SEARCH_MAX_PAGES; pages++) {
+    response = await graphHttpClient.get(response.data["@odata.nextLink"], { headers });
+    inWindow.push(...toGraphEventPage(response.data).events);
+  }
+
+  const events = inWindow.filter((event) => matchesQuery(event, q));
+  const end = offset + maxResults;
+  return { events: events.slice(offset, end), nextPageToken: end < events.length ? String(end) : null };
+}
+
+// Microsoft 365 / Outlook via Microsoft Graph (read-only)
+export const microsoftCalendarProvider: CalendarProvider = {
+  async listEvents(accessToken, { calendarId, maxResults, timeMin, timeMax, pageToken, q }) {
+    const headers = {
+      Authorization: `Bearer ${accessToken}`,
+      Prefer: "outlook.timezone=\"UTC\"",
+    };
+
+    // calendarView expands recurring events, matching Google's singleEvents=true
+    const url = calendarId === "primary"
+      ? `${GRAPH_ORIGIN}/v1.0/me/calendarView`
+      : `${GRAPH_ORIGIN}/v1.0/me/calendars/${encodeURIComponent(calendarId)}/calendarView`;
+
+    const startDateTime = timeMin || new Date().toISOString();
+    const endDateTime = timeMax || new Date(new Date(startDateTime).getTime() + DEFAULT_WINDOW_MS).toISOString();
+    const params = {
+      startDateTime,
+      endDateTime,
+      $orderby: "start/dateTime",
+      $select: "id,subject,isAllDay,start,end,location,bodyPreview",
+    };
+
+    if (q) {
+      return searchGraphCalendarView(url, headers, params, { maxResults, pageToken, q });
+    }
+
+    // Graph pages via @odata.nextLink, which already encodes the window, page size and position
+    if (pageToken) {
+      const response = await graphHttpClient.get(decodeGraphPageToken(pageToken), { headers });
+      return toGraphEventPage(response.data);
+    }
+
+    const response = await graphHttpClient.get(url, { headers, params: { ...params, $top: maxResults } });
+    return toGraphEventPage(response.data);
+  },
+};
+
+// ICS feed subscription - the stored "access token" is the (often secret) feed URL
+export const icsCalendarProvider: CalendarProvider = {
+  async listEvents(feedUrl, { maxResults, timeMin, timeMax, pageToken, q }) {
+    const windowStart = timeMin ? new Date(timeMin) : new Date();
+    const windowEnd = timeMax
+      ? new Date(timeMax)
+      : new Date(windowStart.getTime() + DEFAULT_WINDOW_MS);
+
+    // The whole feed is expanded every time, so a page token is just an offset into the window
+    const offset = pageToken ? Number(pageToken) : 0;
+    if (!Number.isInteger(offset) || offset < 0) {
+      throw new Error("Invalid calendar page token");
+    }
+
+    const inWindow = icsEventsInWindow(parseIcs(await fetchIcsFeed(feedUrl)), windowStart, windowEnd);
+    const events = q ? inWindow.filter((event) => matchesQuery(event, q)) : inWindow;
+    const end = offset + maxResults;
+    return { events: events.slice(offset, end), nextPageToken: end < events.length ? String(end) : null };
+  },
+};
+
+const calendarProviders: { [id in CalendarProviderId]: CalendarProvider } = {
+  google: googleCalendarProvider,
+  microsoft: microsoftCalendarProvider,
+  ics: icsCalendarProvider,
+};
+
+// Look up a provider by id, defaulting to Google for tokens stored before providers existed
+export function getCalendarProvider(id: CalendarProviderId = "google"): CalendarProvider {
+  const provider = calendarProviders[id];
+  if (!provider) {
+    throw new Error(`Unsupported calendar provider: ${id}`);
+  }
+  return provider;
+}
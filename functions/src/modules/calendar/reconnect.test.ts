@@ -0,0 +1,61 @@
+// Reconnect tests - run against the Firestore emulator (npm test), with the Google provider stubbed
+
+import { afterEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { HttpsError } from "firebase-functions/v2/https";
+import { db } from "../../config";
+import { getCalendarEventsWithAuth, storeCalendarTokens } from "./auth";
+import { googleCalendarProvider } from "./providers";
+
+const originalListEvents = googleCalendarProvider.listEvents;
+afterEach(() => {
+  googleCalendarProvider.listEvents = originalListEvents;
+});
+
+test("reconnecting clears needsReconnect and stale grant fields so events load again", async () => {
+  const userId = "reconnect-user";
+  await db.collection("users").doc(userId).set({
+    preferences: { units: "metric" },
+    googleCalendarToken: {
+      access_token: "old-access",
+      refresh_token: "old-refresh",
+      expiry_date: Date.now() - 60 * 60 * 1000,
+      provider: "google",
+      needsReconnect: true,
+    },
+  });
+
+  await assert.rejects(getCalendarEventsWithAuth(userId, { timeMin: "2026-01-01T00:00:00Z" }));
+
+  await storeCalendarTokens(userId, { access_token: "new-access", scope: "https://www.googleapis.com/auth/calendar.readonly" });
+
+  const stored = (await db.collection("users").doc(userId).get()).data();
+  assert.equal(stored?.googleCalendarToken.needsReconnect, undefined);
+  assert.equal(stored?.googleCalendarToken.refresh_token, undefined);
+  assert.equal(stored?.googleCalendarToken.expiry_date, undefined);
+  assert.deepEqual(stored?.preferences, { units: "metric" });
+
+  const tokensUsed: string[] = [];
+  googleCalendarProvider.listEvents = async (accessToken) => {
+    tokensUsed.push(accessToken);
+    return { events: [], nextPageToken: null };
+  };
+
+  const result = await getCalendarEventsWithAuth(userId, { timeMin: "2026-01-02T00:00:00Z" });
+  assert.equal(result.success, true);
+  assert.deepEqual(tokensUsed, ["new-access"]);
+});
+
+test("a token the provider rejects surfaces as reconnect required through the authenticated path", async () => {
+  const userId = "rejected-token-user";
+  await db.collection("users").doc(userId).set({
+    googleCalendarToken: { access_token: "rejected-access", expiry_date: Date.now() + 60 * 60 * 1000, provider: "google" },
+  });
+  googleCalendarProvider.listEvents = async () => {
+    throw Object.assign(new Error("Request failed with status code 401"), { response: { status: 401, data: {} } });
+  };
+
+  await assert.rejects(getCalendarEventsWithAuth(userId, { timeMin: "2026-01-03T00:00:00Z" }),
+    (error: unknown) => error instanceof HttpsError && error.code === "unauthenticated" &&
+      (error.details as { code?: string }).code === "calendar_reconnect_required");
+});
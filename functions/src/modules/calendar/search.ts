@@ -0,0 +1,49 @@
+// Keyword search across the user's calendar events
+
+import { HttpsError } from "firebase-functions/v2/https";
+import { CALENDAR_CONFIG } from "../../config";
+import { CalendarEventsResponse, CalendarSearchRequest } from "../../types";
+import { getCalendarEventsWithAuth } from "./auth";
+
+const DAY_MS = 24 * 60 * 60 * 1000;
+
+// Helper function to parse an optional ISO bound, rejecting junk instead of silently widening the range
+function parseSearchBound(value: unknown, name: string): Date | undefined {
+  if (value === undefined || value === null || value === "") {
+    return undefined;
+  }
+  const date = typeof value === "string" ? new Date(value) : new Date(NaN);
+  if (isNaN(date.getTime())) {
+    throw new HttpsError("invalid-argument", `${name} must be an ISO 8601 date-time, got ${JSON.stringify(value)}`);
+  }
+  return date;
+}
+
+// Search events by keyword within a bounded range (default: the next SEARCH_WINDOW_DAYS days)
+export async function searchCalendarEvents(userId: string, request: CalendarSearchRequest): Promise<CalendarEventsResponse> {
+  const q = typeof request?.q === "string" ? request.q.trim() : "";
+  if (!q) {
+    throw new HttpsError("invalid-argument", "A search query (q) is required");
+  }
+  if (q.length > CALENDAR_CONFIG.MAX_SEARCH_QUERY_LENGTH) {
+    throw new HttpsError("invalid-argument", `q must be at most ${CALENDAR_CONFIG.MAX_SEARCH_QUERY_LENGTH} characters`);
+  }
+
+  const windowMs = CALENDAR_CONFIG.SEARCH_WINDOW_DAYS * DAY_MS;
+  const timeMin = parseSearchBound(request.timeMin, "timeMin") || new Date();
+  const timeMax = parseSearchBound(request.timeMax, "timeMax") || new Date(timeMin.getTime() + windowMs);
+  if (timeMax.getTime() <= timeMin.getTime()) {
+    throw new HttpsError("invalid-argument", "timeMax must be after timeMin");
+  }
+  if (timeMax.getTime() - timeMin.getTime() > windowMs) {
+    throw new HttpsError("invalid-argument", `Search range must be at most ${CALENDAR_CONFIG.SEARCH_WINDOW_DAYS} days`);
+  }
+
+  return await getCalendarEventsWithAuth(userId, {
+    q,
+    timeMin: timeMin.toISOString(),
+    timeMax: timeMax.toISOString(),
+    maxResults: request.maxResults,
+    pageToken: request.pageToken,
+  });
+}
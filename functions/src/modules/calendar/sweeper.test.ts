@@ -0,0 +1,20 @@
+// Refresh failure classification tests
+
+import { test } from "node:test";
+import * as assert from "node:assert/strict";
+import { isRevokedGrantError } from "./sweeper";
+
+test("isRevokedGrantError flags only dead grants", () => {
+  const cases: Array<[string, unknown, boolean]> = [
+    ["invalid_grant response", Object.assign(new Error("invalid_grant"), { response: { status: 400, data: { error: "invalid_grant" } } }), true],
+    ["revoked message", new Error("Token has been expired or revoked."), true],
+    ["server error", Object.assign(new Error("Request failed"), { response: { status: 503, data: { error: "backendError" } } }), false],
+    ["timeout", Object.assign(new Error("timeout of 10000ms exceeded"), { code: "ECONNABORTED" }), false],
+    ["network reset", Object.assign(new Error("socket hang up"), { code: "ECONNRESET" }), false],
+    ["not an error", "invalid_grant", false],
+  ];
+
+  for (const [name, error, expected] of cases) {
+    assert.equal(isRevokedGrantError(error), expected, name);
+  }
+});
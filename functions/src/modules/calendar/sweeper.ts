@@ -0,0 +1,119 @@
+// Scheduled refresh of calendar tokens nearing expiry
+
+import { QueryDocumentSnapshot } from "firebase-admin/firestore";
+import * as logger from "firebase-functions/logger";
+import { db, TOKEN_SWEEPER_CONFIG } from "../../config";
+import { decryptToken, encryptToken } from "../shared/crypto";
+import { createGoogleOAuthClient } from "./oauth";
+
+// Helper function to tell a dead grant apart from a transient failure. Google answers invalid_grant when the
+// refresh token was revoked, expired or issued to a since-deleted client; anything else (timeouts, 5xx, quota)
+// may well succeed on the next attempt
+export function isRevokedGrantError(error: unknown): boolean {
+  const response = (error as { response?: { data?: { error?: unknown } } } | null)?.response;
+  if (response?.data?.error === "invalid_grant") {
+    return true;
+  }
+  return error instanceof Error && /invalid_grant|token has been (expired or )?revoked/i.test(error.message);
+}
+
+// Refresh one user's Google access token and persist it. Resolves to the new access token, or null (after
+// flagging the connection) when Google says the grant is gone and the user has to reconnect. Transient
+// failures are thrown and leave the connection alone
+export async function refreshStoredCalendarToken(userId: string, refreshToken: string): Promise<string | null> {
+  const userRef = db.collection("users").doc(userId);
+
+  let accessToken: string | null | undefined;
+  let expiryDate: number | null | undefined;
+  let rotatedRefreshToken: string | null | undefined;
+  try {
+    const oAuth2Client = createGoogleOAuthClient();
+    oAuth2Client.setCredentials({ refresh_token: refreshToken });
+
+    // getAccessToken refreshes when only a refresh token is present
+    await oAuth2Client.getAccessToken();
+    ({ access_token: accessToken, expiry_date: expiryDate, refresh_token: rotatedRefreshToken } = oAuth2Client.credentials);
+  } catch (error) {
+    if (!isRevokedGrantError(error)) {
+      throw error;
+    }
+    logger.warn(`Calendar grant revoked for user ${userId}, flagging for reconnect:`, error instanceof Error ? error.message : error);
+    await userRef.update({ "googleCalendarToken.needsReconnect": true });
+    return null;
+  }
+
+  if (!accessToken) {
+    throw new Error("Refresh returned no access token");
+  }
+
+  await userRef.update({
+    "googleCalendarToken.access_token": encryptToken(accessToken),
+    "googleCalendarToken.lastUpdated": new Date().toISOString(),
+    ...(expiryDate && { "googleCalendarToken.expiry_date": expiryDate }),
+    ...(rotatedRefreshToken && { "googleCalendarToken.refresh_token": encryptToken(rotatedRefreshToken) }),
+  });
+  return accessToken;
+}
+
+// Find Google calendar tokens close to expiry and refresh them so idle connections stay alive
+export async function sweepExpiringCalendarTokens(): Promise<{ refreshed: number; failed: number; skipped: number }> {
+  const now = Date.now();
+  // lastUpdated is always written; expiry_date only when the backend stored the token
+  const staleBefore = new Date(now - (TOKEN_SWEEPER_CONFIG.ACCESS_TOKEN_LIFETIME_MS - TOKEN_SWEEPER_CONFIG.REFRESH_LEAD_MS)).toISOString();
+  let refreshed = 0;
+  let failed = 0;
+  let skipped = 0;
+  let lastDoc: QueryDocumentSnapshot | undefined;
+
+  // Page through every candidate so skipped tokens can't starve the rest of the batch
+  for (;;) {
+    let query = db.collection("users")
+      .where("googleCalendarToken.lastUpdated", "<", staleBefore)
+      .orderBy("googleCalendarToken.lastUpdated")
+      .limit(TOKEN_SWEEPER_CONFIG.BATCH_SIZE);
+    if (lastDoc) {
+      query = query.startAfter(lastDoc);
+    }
+
+    const snapshot = await query.get();
+
+    for (const doc of snapshot.docs) {
+      const token = doc.data().googleCalendarToken;
+      const provider = token?.provider || "google";
+      const expiresSoon = !token?.expiry_date || token.expiry_date < now + TOKEN_SWEEPER_CONFIG.REFRESH_LEAD_MS;
+
+      if (provider !== "google" || !token?.refresh_token || token.needsReconnect || !expiresSoon) {
+        continue;
+      }
+
+      let refreshToken: string;
+      try {
+        refreshToken = decryptToken(token.refresh_token);
+      } catch (error) {
+        logger.error(`Could not decrypt refresh token for user ${doc.id}:`, error);
+        failed++;
+        continue;
+      }
+
+      try {
+        if (await refreshStoredCalendarToken(doc.id, refreshToken) !== null) {
+          refreshed++;
+        } else {
+          failed++;
+        }
+      } catch (error) {
+        // Transient - the connection is left as-is and picked up again on the next sweep
+        logger.warn(`Calendar token refresh failed for user ${doc.id}, retrying next sweep:`, error instanceof Error ? error.message : error);
+        skipped++;
+      }
+    }
+
+    if (snapshot.size < TOKEN_SWEEPER_CONFIG.BATCH_SIZE) {
+      break;
+    }
+    lastDoc = snapshot.docs[snapshot.docs.length - 1];
+  }
+
+  logger.info(`Calendar token sweep complete: ${refreshed} refreshed, ${failed} flagged for reconnect, ${skipped} skipped after transient errors`);
+  return { refreshed, failed, skipped };
+}
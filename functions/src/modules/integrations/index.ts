@@ -0,0 +1,32 @@
+// Integrations module - consolidated connection status across providers
+
+import * as logger from "firebase-functions/logger";
+import { IntegrationStatus, IntegrationsStatusResponse } from "../../types";
+import { getCalendarIntegrationStatus } from "../calendar";
+
+// Status lookup per integration - register new integrations here
+const integrationStatusProviders: { [integration: string]: (userId: string) => Promise<IntegrationStatus> } = {
+  calendar: getCalendarIntegrationStatus,
+};
+
+// Get the status of every integration for a user
+export async function getIntegrationsStatus(userId: string): Promise<IntegrationsStatusResponse> {
+  const integrations: { [integration: string]: IntegrationStatus } = {};
+
+  await Promise.all(
+    Object.entries(integrationStatusProviders).map(async ([integration, getStatus]) => {
+      try {
+        integrations[integration] = await getStatus(userId);
+      } catch (error) {
+        // One failing integration shouldn't hide the others
+        logger.error(`Error checking ${integration} integration status:`, error);
+        integrations[integration] = { connected: false, needsReconnect: false, lastSync: null };
+      }
+    })
+  );
+
+  return {
+    success: true,
+    integrations,
+  };
+}
@@ -0,0 +1,80 @@
+// Saved location tests - run against the Firestore emulator (npm test)
+
+import { afterEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { HttpsError } from "firebase-functions/v2/https";
+import { SAVED_LOCATIONS_CONFIG } from "../../config";
+import { WeatherRequest } from "../../types";
+import { weatherHttpClient } from "../shared/http";
+import { createSavedLocation, listSavedLocations, resolveRequestLocation, updateSavedLocation } from "./index";
+
+const originalCap = SAVED_LOCATIONS_CONFIG.MAX_PER_USER;
+const originalGet = weatherHttpClient.get;
+afterEach(() => {
+  SAVED_LOCATIONS_CONFIG.MAX_PER_USER = originalCap;
+  weatherHttpClient.get = originalGet;
+  delete process.env.weather_api_key;
+});
+
+// Helper function to match an HttpsError by code
+const withCode = (code: string) => (error: unknown) => error instanceof HttpsError && error.code === code;
+
+test("creating beyond the per-user cap is refused", async () => {
+  SAVED_LOCATIONS_CONFIG.MAX_PER_USER = 2;
+  const userId = "locations-cap-user";
+  await createSavedLocation(userId, { name: "Home", latitude: 40.7, longitude: -74 });
+  await createSavedLocation(userId, { name: "Work", latitude: 40.75, longitude: -73.98 });
+
+  await assert.rejects(createSavedLocation(userId, { name: "Cabin", latitude: 44.3, longitude: -71.3 }), withCode("resource-exhausted"));
+  assert.equal((await listSavedLocations(userId)).length, 2);
+});
+
+test("names are unique per user, ignoring case and surrounding space", async () => {
+  const userId = "locations-duplicate-user";
+  const home = await createSavedLocation(userId, { name: "Home", latitude: 40.7, longitude: -74 });
+  const work = await createSavedLocation(userId, { name: "Work", latitude: 40.75, longitude: -73.98 });
+
+  await assert.rejects(createSavedLocation(userId, { name: "  home ", latitude: 1, longitude: 1 }), withCode("already-exists"));
+  await assert.rejects(updateSavedLocation(userId, work.id, { name: "HOME" }), withCode("already-exists"));
+  // Renaming a location to its own name (in another case) is fine
+  assert.equal((await updateSavedLocation(userId, home.id, { name: "HOME" })).name, "HOME");
+});
+
+test("coordinates outside -90..90 / -180..180 are refused on create and update", async () => {
+  const userId = "locations-coordinates-user";
+  const invalid: Array<[unknown, unknown]> = [[91, 0], [-90.5, 0], [0, 180.1], [0, -181], [Number.NaN, 0], ["40.7", -74], [40.7, undefined]];
+
+  for (const [latitude, longitude] of invalid) {
+    await assert.rejects(
+      createSavedLocation(userId, { name: "Bad", latitude, longitude } as Parameters<typeof createSavedLocation>[1]),
+      withCode("invalid-argument"),
+      `${latitude},${longitude}`
+    );
+  }
+
+  const edge = await createSavedLocation(userId, { name: "Edge", latitude: -90, longitude: 180 });
+  await assert.rejects(updateSavedLocation(userId, edge.id, { latitude: 95, longitude: 0 }), withCode("invalid-argument"));
+  assert.deepEqual((await listSavedLocations(userId)).map((location) => location.name), ["Edge"]);
+});
+
+test("a query is geocoded for every endpoint instead of skipping the location fallback", async () => {
+  process.env.weather_api_key = "test-key";
+  weatherHttpClient.get = (async () => ({ status: 200, data: [{ lat: 51.5072, lon: -0.1276 }], headers: {} })) as
+    unknown as typeof weatherHttpClient.get;
+
+  const data: WeatherRequest = { query: "London resolve test", units: "metric" };
+  const { request, locationInferred } = await resolveRequestLocation(undefined, data);
+  assert.equal(locationInferred, false);
+  assert.equal(request.latitude, 51.5072);
+  assert.equal(request.longitude, -0.1276);
+  assert.equal(request.units, "metric");
+});
+
+test("a non-string query is invalid-argument", async () => {
+  await assert.rejects(resolveRequestLocation(undefined, { query: 42 as unknown as string }), withCode("invalid-argument"));
+});
+
+test("explicit coordinates win over a query and are passed through untouched", async () => {
+  const data = { latitude: 10, longitude: 20, query: "ignored" };
+  assert.deepEqual(await resolveRequestLocation(undefined, data), { request: data, locationInferred: false });
+});
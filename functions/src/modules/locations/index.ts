@@ -0,0 +1,196 @@
+// Saved locations (home, work, cabin...) stored per user
+
+import { HttpsError } from "firebase-functions/v2/https";
+import { db, FALLBACK_LOCATION, SAVED_LOCATIONS_CONFIG, weatherApiKey } from "../../config";
+import { SavedLocation, SavedLocationInput } from "../../types";
+import { geocodeQuery, validateCoordinates } from "../shared/location";
+
+const MAX_NAME_LENGTH = 50;
+
+// Helper function to get a user's saved locations collection
+function locationsCollection(userId: string) {
+  return db.collection("users").doc(userId).collection("savedLocations");
+}
+
+// Helper function to validate and trim a location name
+function validateName(name: unknown): string {
+  if (typeof name !== "string" || !name.trim()) {
+    throw new HttpsError("invalid-argument", "Location name is required");
+  }
+  const trimmed = name.trim();
+  if (trimmed.length > MAX_NAME_LENGTH) {
+    throw new HttpsError("invalid-argument", `Location name must be at most ${MAX_NAME_LENGTH} characters`);
+  }
+  return trimmed;
+}
+
+// List a user's saved locations, default first then by name
+export async function listSavedLocations(userId: string): Promise<SavedLocation[]> {
+  const snapshot = await locationsCollection(userId).get();
+  return snapshot.docs
+    .map((doc) => ({ id: doc.id, ...doc.data() } as SavedLocation))
+    .sort((a, b) => Number(b.isDefault) - Number(a.isDefault) || a.name.localeCompare(b.name));
+}
+
+// Create a saved location - the per-user cap, name uniqueness and the single default are enforced in one transaction
+export async function createSavedLocation(userId: string, input: SavedLocationInput): Promise<SavedLocation> {
+  const name = validateName(input.name);
+  validateCoordinates(input.latitude, input.longitude);
+  const collection = locationsCollection(userId);
+  const docRef = collection.doc();
+
+  return await db.runTransaction(async (transaction) => {
+    const existing = await transaction.get(collection);
+    if (existing.size >= SAVED_LOCATIONS_CONFIG.MAX_PER_USER) {
+      throw new HttpsError("resource-exhausted", `At most ${SAVED_LOCATIONS_CONFIG.MAX_PER_USER} saved locations are allowed`);
+    }
+    if (existing.docs.some((doc) => String(doc.get("name")).toLowerCase() === name.toLowerCase())) {
+      throw new HttpsError("already-exists", `A saved location named "${name}" already exists`);
+    }
+
+    // The first location becomes the default unless the caller says otherwise
+    const isDefault = input.isDefault !== undefined ? input.isDefault === true : existing.empty;
+    if (isDefault) {
+      existing.docs.filter((doc) => doc.get("isDefault")).forEach((doc) => transaction.update(doc.ref, { isDefault: false }));
+    }
+
+    const now = new Date().toISOString();
+    const location: Omit<SavedLocation, "id"> = {
+      name,
+      latitude: input.latitude as number,
+      longitude: input.longitude as number,
+      isDefault,
+      createdAt: now,
+      updatedAt: now,
+    };
+    transaction.set(docRef, location);
+    return { id: docRef.id, ...location };
+  });
+}
+
+// Update a saved location's name, coordinates or default flag
+export async function updateSavedLocation(userId: string, locationId: string, input: SavedLocationInput): Promise<SavedLocation> {
+  const name = input.name !== undefined ? validateName(input.name) : undefined;
+  if (input.latitude !== undefined || input.longitude !== undefined) {
+    validateCoordinates(input.latitude, input.longitude);
+  }
+  const collection = locationsCollection(userId);
+
+  return await db.runTransaction(async (transaction) => {
+    const existing = await transaction.get(collection);
+    const current = existing.docs.find((doc) => doc.id === locationId);
+    if (!current) {
+      throw new HttpsError("not-found", `Saved location ${locationId} not found`);
+    }
+
+    if (name && existing.docs.some((doc) => doc.id !== locationId && String(doc.get("name")).toLowerCase() === name.toLowerCase())) {
+      throw new HttpsError("already-exists", `A saved location named "${name}" already exists`);
+    }
+
+    if (input.isDefault === true) {
+      existing.docs
+        .filter((doc) => doc.id !== locationId && doc.get("isDefault"))
+        .forEach((doc) => transaction.update(doc.ref, { isDefault: false }));
+    }
+
+    const updates: Partial<SavedLocation> = { updatedAt: new Date().toISOString() };
+    if (name) {
+      updates.name = name;
+    }
+    if (input.latitude !== undefined && input.longitude !== undefined) {
+      updates.latitude = input.latitude;
+      updates.longitude = input.longitude;
+    }
+    if (input.isDefault !== undefined) {
+      updates.isDefault = input.isDefault === true;
+    }
+
+    transaction.update(current.ref, updates);
+    return { ...(current.data() as Omit<SavedLocation, "id">), ...updates, id: locationId };
+  });
+}
+
+// Delete a saved location
+export async function deleteSavedLocation(userId: string, locationId: string): Promise<void> {
+  const docRef = locationsCollection(userId).doc(locationId);
+  const doc = await docRef.get();
+  if (!doc.exists) {
+    throw new HttpsError("not-found", `Saved location ${locationId} not found`);
+  }
+  await docRef.delete();
+}
+
+// Resolve a saved location ID to its coordinates for the weather endpoints
+export async function resolveSavedLocation(userId: string | undefined, locationId: unknown): Promise<{latitude: number; longitude: number}> {
+  if (!userId) {
+    throw new HttpsError("unauthenticated", "Saved locations require authentication");
+  }
+  if (typeof locationId !== "string" || !locationId) {
+    throw new HttpsError("invalid-argument", "locationId must be a non-empty string");
+  }
+
+  const doc = await locationsCollection(userId).doc(locationId).get();
+  if (!doc.exists) {
+    throw new HttpsError("not-found", `Saved location ${locationId} not found`);
+  }
+  return { latitude: doc.get("latitude"), longitude: doc.get("longitude") };
+}
+
+// Helper function to find the user's default saved location, if any
+async function getDefaultSavedLocation(userId: string): Promise<{latitude: number; longitude: number} | null> {
+  const snapshot = await locationsCollection(userId).where("isDefault", "==", true).limit(1).get();
+  if (snapshot.empty) {
+    return null;
+  }
+  const doc = snapshot.docs[0];
+  return { latitude: doc.get("latitude"), longitude: doc.get("longitude") };
+}
+
+// Helper function to geocode a request's city name or ZIP, so every weather endpoint accepts a query
+async function geocodeRequestQuery(query: unknown): Promise<{latitude: number; longitude: number}> {
+  if (typeof query !== "string") {
+    throw new HttpsError("invalid-argument", "Location query must be a string");
+  }
+
+  let apiKey: string;
+  try {
+    apiKey = weatherApiKey.value().trim();
+  } catch {
+    apiKey = process.env.WEATHER_API_KEY || "";
+  }
+  if (!apiKey) {
+    throw new Error("Location search requires a weather API key");
+  }
+  return await geocodeQuery(query, apiKey);
+}
+
+// Fill in a weather request's coordinates: a locationId resolves to the saved location, a query (city name or
+// ZIP) is geocoded, and a request with no location at all uses the user's default saved location, else the
+// deployment fallback (flagged as inferred)
+export async function resolveRequestLocation<T extends {latitude?: number; longitude?: number; query?: string; locationId?: string}>(
+  userId: string | undefined,
+  data: T
+): Promise<{request: T; locationInferred: boolean}> {
+  if (data?.locationId !== undefined) {
+    return { request: { ...data, ...(await resolveSavedLocation(userId, data.locationId)) }, locationInferred: false };
+  }
+
+  const hasCoordinates = data?.latitude !== undefined || data?.longitude !== undefined;
+  if (hasCoordinates) {
+    return { request: data, locationInferred: false };
+  }
+
+  if (data?.query !== undefined) {
+    return { request: { ...data, ...(await geocodeRequestQuery(data.query)) }, locationInferred: false };
+  }
+
+  const defaultLocation = userId ? await getDefaultSavedLocation(userId) : null;
+  if (defaultLocation) {
+    return { request: { ...data, ...defaultLocation }, locationInferred: false };
+  }
+
+  return {
+    request: { ...data, latitude: FALLBACK_LOCATION.LATITUDE, longitude: FALLBACK_LOCATION.LONGITUDE },
+    locationInferred: true,
+  };
+}
@@ -0,0 +1,77 @@
+// Callable auth tests - the revocation check is stubbed, no Auth emulator needed
+
+import { afterEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import type { CallableRequest } from "firebase-functions/v2/https";
+import { HttpsError } from "firebase-functions/v2/https";
+import { auth } from "../../config";
+import { allowRefreshForUser, authenticateAdminCallable, authenticateCallable, resolveCallableUser } from "./auth";
+
+const originalVerifyIdToken = auth.verifyIdToken;
+afterEach(() => {
+  auth.verifyIdToken = originalVerifyIdToken;
+});
+
+// Helper function to build a callable request as the SDK hands it over after verifying the token
+function callableRequest(uid?: string, claims: Record<string, unknown> = {}): CallableRequest {
+  return {
+    data: {},
+    auth: uid ? { uid, token: { uid, ...claims }, rawToken: "id-token" } : undefined,
+    rawRequest: { headers: uid ? { authorization: "Bearer id-token" } : {} },
+  } as unknown as CallableRequest;
+}
+
+// Helper function to make the revocation check accept or reject every token
+function stubVerifyIdToken(revoked: boolean): string[] {
+  const checked: string[] = [];
+  auth.verifyIdToken = (async (token: string, checkRevoked?: boolean) => {
+    assert.equal(checkRevoked, true);
+    checked.push(token);
+    if (revoked) {
+      throw new Error("The Firebase ID token has been revoked.");
+    }
+    return { uid: "user-a" };
+  }) as typeof auth.verifyIdToken;
+  return checked;
+}
+
+test("resolveCallableUser treats an anonymous caller as anonymous without a lookup", async () => {
+  const checked = stubVerifyIdToken(false);
+  assert.equal(await resolveCallableUser(callableRequest()), undefined);
+  assert.deepEqual(checked, []);
+});
+
+test("resolveCallableUser re-checks the token for revocation", async () => {
+  const checked = stubVerifyIdToken(false);
+  assert.equal(await resolveCallableUser(callableRequest("user-a")), "user-a");
+  assert.deepEqual(checked, ["id-token"]);
+});
+
+test("resolveCallableUser rejects a revoked session instead of treating it as anonymous", async () => {
+  stubVerifyIdToken(true);
+  await assert.rejects(resolveCallableUser(callableRequest("user-a")),
+    (error: unknown) => error instanceof HttpsError && error.code === "unauthenticated");
+});
+
+test("authenticateCallable requires a signed-in caller", async () => {
+  stubVerifyIdToken(false);
+  await assert.rejects(authenticateCallable(callableRequest()),
+    (error: unknown) => error instanceof HttpsError && error.code === "unauthenticated");
+});
+
+test("authenticateAdminCallable requires the admin claim and an unrevoked session", async () => {
+  stubVerifyIdToken(false);
+  await assert.rejects(authenticateAdminCallable(callableRequest("user-a")),
+    (error: unknown) => error instanceof HttpsError && error.code === "permission-denied");
+  assert.equal(await authenticateAdminCallable(callableRequest("user-a", { admin: true })), "user-a");
+
+  stubVerifyIdToken(true);
+  await assert.rejects(authenticateAdminCallable(callableRequest("user-a", { admin: true })),
+    (error: unknown) => error instanceof HttpsError && error.code === "unauthenticated");
+});
+
+test("allowRefreshForUser keeps refresh for signed-in callers and drops it for anonymous ones", () => {
+  assert.deepEqual(allowRefreshForUser("user-a", { latitude: 1, refresh: true }), { latitude: 1, refresh: true });
+  assert.deepEqual(allowRefreshForUser(undefined, { latitude: 1, refresh: true }), { latitude: 1, refresh: false });
+  assert.deepEqual(allowRefreshForUser(undefined, { latitude: 1 }), { latitude: 1 });
+});
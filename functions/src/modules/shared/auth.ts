@@ -0,0 +1,79 @@
+// Bearer authentication for onRequest endpoints, and revocation checks for callables
+
+import type { CallableRequest, Request } from "firebase-functions/v2/https";
+import { HttpsError } from "firebase-functions/v2/https";
+import type { Response } from "express";
+import * as logger from "firebase-functions/logger";
+import { auth } from "../../config";
+
+// Helper function to pull the ID token out of "Authorization: Bearer <token>"
+function getBearerToken(request: Request): string | null {
+  const match = (request.headers.authorization || "").match(/^Bearer\s+(\S+)$/i);
+  return match ? match[1] : null;
+}
+
+// Verify the Firebase ID token in "Authorization: Bearer <token>" and return the user ID.
+// Sends a 401 and resolves to null when the header is missing or malformed, or the token is
+// invalid, expired or revoked - callers just return in that case
+export async function authenticateRequest(request: Request, response: Response): Promise<string | null> {
+  const token = getBearerToken(request);
+  if (!token) {
+    response.status(401).json({ success: false, error: "No Firebase token provided" });
+    return null;
+  }
+
+  try {
+    // checkRevoked so tokens issued before a logout stop working immediately
+    const decodedToken = await auth.verifyIdToken(token, true);
+    return decodedToken.uid;
+  } catch (error) {
+    logger.warn("❌ Rejected Firebase token:", error instanceof Error ? error.message : error);
+    response.status(401).json({ success: false, error: "Invalid or expired Firebase token" });
+    return null;
+  }
+}
+
+// Return the caller's user ID for a callable that works signed in or not - undefined when anonymous.
+// The callable SDK verifies the ID token but never checks revocation, so the token is checked again here
+// and a session revoked by logout or account deletion is rejected rather than treated as anonymous
+export async function resolveCallableUser(request: CallableRequest): Promise<string | undefined> {
+  const userId = request.auth?.uid;
+  if (!userId) {
+    return undefined;
+  }
+
+  const token = getBearerToken(request.rawRequest);
+  try {
+    if (!token) {
+      throw new Error("No Firebase token provided");
+    }
+    await auth.verifyIdToken(token, true);
+  } catch (error) {
+    logger.warn(`❌ Rejected callable token for user ${userId}:`, error instanceof Error ? error.message : error);
+    throw new HttpsError("unauthenticated", "Invalid or expired Firebase token");
+  }
+  return userId;
+}
+
+// Honor refresh (skip the cache read) only for signed-in callers, so anonymous traffic can't force upstream fetches
+export function allowRefreshForUser<T extends { refresh?: boolean }>(userId: string | undefined, data: T): T {
+  return userId || !data?.refresh ? data : { ...data, refresh: false };
+}
+
+// Return the caller's user ID for a callable that requires sign-in, rejecting revoked sessions
+export async function authenticateCallable(request: CallableRequest): Promise<string> {
+  const userId = await resolveCallableUser(request);
+  if (!userId) {
+    throw new HttpsError("unauthenticated", "User must be authenticated");
+  }
+  return userId;
+}
+
+// Return the caller's user ID for an admin-only callable (custom claim admin: true), rejecting revoked sessions
+export async function authenticateAdminCallable(request: CallableRequest): Promise<string> {
+  const userId = await authenticateCallable(request);
+  if (request.auth?.token.admin !== true) {
+    throw new HttpsError("permission-denied", "Admin access required");
+  }
+  return userId;
+}
@@ -0,0 +1,58 @@
+// Weather cache tests - reads and writes go to memory and the Firestore emulator (npm test)
+
+import { test } from "node:test";
+import * as assert from "node:assert/strict";
+import { WEATHER_CONFIG } from "../../config";
+import {
+  deleteCachedWeatherData, getCacheGeneration, getCachedWeatherData, getForecastCacheKey, getLocationCacheKeys, setCachedWeatherData,
+} from "./cache";
+
+const TTL = 60 * 1000;
+
+test("a write carrying the current generation is cached", async () => {
+  const key = "location:10.00:20.00";
+  await setCachedWeatherData(key, "Current Town, XX", TTL, getCacheGeneration(key));
+  assert.equal(await getCachedWeatherData(key, TTL), "Current Town, XX");
+});
+
+test("a write whose fetch started before a clear is dropped from memory and Firestore", async () => {
+  const key = "location:11.00:21.00";
+  await setCachedWeatherData(key, "Old Town, XX", TTL);
+
+  const generation = getCacheGeneration(key); // Taken as the fetch starts
+  await deleteCachedWeatherData([key]);
+  assert.equal(getCacheGeneration(key), generation + 1);
+
+  await setCachedWeatherData(key, "Stale Town, XX", TTL, generation);
+  assert.equal(await getCachedWeatherData(key, TTL), null);
+
+  // A fetch that started after the clear caches as usual
+  await setCachedWeatherData(key, "New Town, XX", TTL, getCacheGeneration(key));
+  assert.equal(await getCachedWeatherData(key, TTL), "New Town, XX");
+});
+
+test("writes without a generation are unconditional", async () => {
+  const key = "location:12.00:22.00";
+  await deleteCachedWeatherData([key]);
+  await setCachedWeatherData(key, "Any Town, XX", TTL);
+  assert.equal(await getCachedWeatherData(key, TTL), "Any Town, XX");
+});
+
+test("forecast keys carry the API version, defaulting to the configured one", () => {
+  assert.equal(getForecastCacheKey(37.7749, -122.4194, "metric", "2.5"), "forecast-2.5:37.775:-122.419:metric");
+  assert.equal(getForecastCacheKey(37.7749, -122.4194, "imperial", "3.0"), "forecast-3.0:37.775:-122.419:imperial");
+  assert.equal(getForecastCacheKey(37.7749, -122.4194, "metric"), `forecast-${WEATHER_CONFIG.API_VERSION}:37.775:-122.419:metric`);
+});
+
+test("a location clear covers both unit systems and every API version's forecast", () => {
+  assert.deepEqual(getLocationCacheKeys(37.7749, -122.4194).sort(), [
+    "air_quality:37.775:-122.419",
+    "current:37.775:-122.419:imperial",
+    "current:37.775:-122.419:metric",
+    "forecast-2.5:37.775:-122.419:imperial",
+    "forecast-2.5:37.775:-122.419:metric",
+    "forecast-3.0:37.775:-122.419:imperial",
+    "forecast-3.0:37.775:-122.419:metric",
+    "location:37.77:-122.42",
+  ]);
+});
@@ -1,20 +1,55 @@
 // Caching utilities
 
+import { createHash } from "crypto";
 import * as logger from "firebase-functions/logger";
-import { db } from "../../config";
-import { WeatherData, ForecastData } from "../../types";
+import { FieldPath, Query } from "firebase-admin/firestore";
+import { db, CACHE_NAMESPACE, CACHE_TTL, GEOCODE_CONFIG, FORECAST_DAYS_BY_API_VERSION, WEATHER_CONFIG, WeatherApiVersion } from "../../config";
+import { WeatherData, ForecastData, AirQualityData, CacheStats } from "../../types";
 
 // In-memory cache for weather data
-const weatherCache = new Map<string, {data: WeatherData | ForecastData | string; timestamp: number; ttl: number}>();
+const weatherCache = new Map<string, {data: WeatherData | ForecastData | AirQualityData | string; timestamp: number; ttl: number}>();
 
-// Helper function to generate cache key
+// Per-key invalidation generation on this instance, bumped by every delete. A fetch takes the generation before it
+// calls the provider and hands it to setCachedWeatherData, so a result fetched across a clear isn't written back
+const cacheGenerations = new Map<string, number>();
+
+// Hit/miss counters for this instance, reported by getCacheStats
+const cacheCounters = { memoryHits: 0, firestoreHits: 0, misses: 0 };
+
+// Helper function to generate cache key - "<type>:<lat>:<lon>:<units>" with coordinates rounded to 3 decimals
+// (~100m), e.g. "current:37.775:-122.419:metric". CACHE_NAMESPACE is prepended on every read and write
 export function getCacheKey(type: string, latitude: number, longitude: number, units: string): string {
   return `${type}:${Math.round(latitude * 1000) / 1000}:${Math.round(longitude * 1000) / 1000}:${units}`;
 }
 
-// Helper function to generate location cache key (no units needed)
+// Helper function to generate forecast cache key - the API version is part of the type, since a 2.5 forecast
+// (5 days) can't answer a One Call request for 8, e.g. "forecast-3.0:37.775:-122.419:metric"
+export function getForecastCacheKey(latitude: number, longitude: number, units: string, apiVersion: WeatherApiVersion = WEATHER_CONFIG.API_VERSION): string {
+  return getCacheKey(`forecast-${apiVersion}`, latitude, longitude, units);
+}
+
+// Helper function to generate location (reverse geocoding) cache key - no units, and coarser than the weather
+// keys (GEOCODE_CONFIG.CACHE_PRECISION decimals) since a place name covers far more than 100m
 export function getLocationCacheKey(latitude: number, longitude: number): string {
-  return `location:${Math.round(latitude * 1000) / 1000}:${Math.round(longitude * 1000) / 1000}`;
+  return `location:${latitude.toFixed(GEOCODE_CONFIG.CACHE_PRECISION)}:${longitude.toFixed(GEOCODE_CONFIG.CACHE_PRECISION)}`;
+}
+
+// Helper function to generate air quality cache key (concentrations don't depend on units)
+export function getAirQualityCacheKey(latitude: number, longitude: number): string {
+  return `air_quality:${Math.round(latitude * 1000) / 1000}:${Math.round(longitude * 1000) / 1000}`;
+}
+
+// Helper function to generate geocoding cache key for a free-text location query, so "New  York" and
+// "new york" share an entry. The normalized text is hashed: the key is a document ID, where "/" would be a
+// path separator, and the stored key shouldn't spell out what people searched for
+export function getGeocodeCacheKey(query: string): string {
+  const normalized = query.trim().toLowerCase().replace(/\s+/g, " ");
+  return `geocode:${createHash("sha256").update(normalized).digest("hex")}`;
+}
+
+// Helper function to apply the environment namespace - every read and write goes through this
+function namespacedKey(cacheKey: string): string {
+  return `${CACHE_NAMESPACE}${cacheKey}`;
 }
 
 // Helper function to check if cache is valid
@@ -22,12 +57,20 @@ export function isCacheValid(timestamp: number, ttl: number): boolean {
   return Date.now() - timestamp < ttl;
 }
 
+// Helper function to get a key's invalidation generation - take it before fetching, pass it when caching the result
+export function getCacheGeneration(key: string): number {
+  return cacheGenerations.get(namespacedKey(key)) || 0;
+}
+
 // Helper function to get cached data
-export async function getCachedWeatherData(cacheKey: string, ttl: number): Promise<WeatherData | ForecastData | string | null> {
+export async function getCachedWeatherData(key: string, ttl: number): Promise<WeatherData | ForecastData | AirQualityData | string | null> {
+  const cacheKey = namespacedKey(key);
+
   // Check in-memory cache first
   const memoryCache = weatherCache.get(cacheKey);
   if (memoryCache && isCacheValid(memoryCache.timestamp, memoryCache.ttl)) {
     logger.info(`Cache hit (memory): ${cacheKey}`);
+    cacheCounters.memoryHits++;
     return memoryCache.data;
   }
 
@@ -44,6 +87,7 @@ export async function getCachedWeatherData(cacheKey: string, ttl: number): Promi
           timestamp: cacheData.timestamp,
           ttl: ttl
         });
+        cacheCounters.firestoreHits++;
         return cacheData.data;
       }
     }
@@ -51,12 +95,25 @@ export async function getCachedWeatherData(cacheKey: string, ttl: number): Promi
     logger.warn("Firestore cache read failed");
   }
 
+  cacheCounters.misses++;
   return null;
 }
 
-// Helper function to set cached data
-export async function setCachedWeatherData(cacheKey: string, data: WeatherData | ForecastData | string, ttl: number): Promise<void> {
+// Helper function to set cached data. With a generation from getCacheGeneration, the write is dropped when the key
+// was cleared since - the data was fetched before the clear, and an admin clear or refresh shouldn't be undone by it
+export async function setCachedWeatherData(
+  key: string,
+  data: WeatherData | ForecastData | AirQualityData | string,
+  ttl: number,
+  generation?: number
+): Promise<void> {
+  const cacheKey = namespacedKey(key);
   const timestamp = Date.now();
+
+  if (generation !== undefined && generation !== getCacheGeneration(key)) {
+    logger.info(`Cache write skipped, cleared while fetching: ${cacheKey}`);
+    return;
+  }
   
   // Update memory cache
   weatherCache.set(cacheKey, { data, timestamp, ttl });
@@ -66,10 +123,63 @@ export async function setCachedWeatherData(cacheKey: string, data: WeatherData |
     await db.collection("weather_cache").doc(cacheKey).set({
       data,
       timestamp,
-      ttl: 30 * 60 * 1000 // 30 minutes for Firestore backup
+      ttl: CACHE_TTL.FIRESTORE_CACHE // Longer-lived backup; reads still apply the caller's TTL
     });
     logger.info(`Cache set: ${cacheKey}`);
   } catch {
     logger.warn("Firestore cache write failed");
   }
 }
+
+// Every cache key holding data for a location, in both unit systems and every API version's forecast - what an
+// admin clear removes
+export function getLocationCacheKeys(latitude: number, longitude: number): string[] {
+  const versions = Object.keys(FORECAST_DAYS_BY_API_VERSION) as WeatherApiVersion[];
+  const weatherKeys = ["metric", "imperial"].reduce((keys: string[], units) => keys.concat(
+    [getCacheKey("current", latitude, longitude, units)],
+    versions.map((version) => getForecastCacheKey(latitude, longitude, units, version))
+  ), []);
+  return weatherKeys.concat([getAirQualityCacheKey(latitude, longitude), getLocationCacheKey(latitude, longitude)]);
+}
+
+// Delete cached entries from memory and Firestore, and bump their generation so fetches already in flight on this
+// instance don't write them back. Other instances' memory caches keep their copy until it expires
+export async function deleteCachedWeatherData(keys: string[]): Promise<void> {
+  const cacheKeys = keys.map(namespacedKey);
+  cacheKeys.forEach((cacheKey) => {
+    weatherCache.delete(cacheKey);
+    cacheGenerations.set(cacheKey, (cacheGenerations.get(cacheKey) || 0) + 1);
+  });
+
+  const batch = db.batch();
+  cacheKeys.forEach((cacheKey) => batch.delete(db.collection("weather_cache").doc(cacheKey)));
+  await batch.commit();
+  logger.info(`Cache cleared: ${cacheKeys.join(", ")}`);
+}
+
+// Report cache size and hit ratio - counters are per instance, the Firestore count covers this namespace only
+export async function getCacheStats(): Promise<CacheStats> {
+  let firestoreEntries: number | null = null;
+  try {
+    // An aggregation count over the namespace's key range, not a document scan
+    let query: Query = db.collection("weather_cache");
+    if (CACHE_NAMESPACE) {
+      query = query
+        .where(FieldPath.documentId(), ">=", CACHE_NAMESPACE)
+        .where(FieldPath.documentId(), "<", `${CACHE_NAMESPACE}\uf8ff`);
+    }
+    const snapshot = await query.count().get();
+    firestoreEntries = snapshot.data().count;
+  } catch (error) {
+    logger.warn("Firestore cache count failed:", error);
+  }
+
+  const lookups = cacheCounters.memoryHits + cacheCounters.firestoreHits + cacheCounters.misses;
+  return {
+    namespace: CACHE_NAMESPACE,
+    memoryEntries: weatherCache.size,
+    firestoreEntries,
+    ...cacheCounters,
+    hitRatio: lookups > 0 ? (cacheCounters.memoryHits + cacheCounters.firestoreHits) / lookups : null,
+  };
+}
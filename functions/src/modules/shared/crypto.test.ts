@@ -0,0 +1,68 @@
+// Token encryption tests - AES-GCM round trip, stored format, legacy plaintext and the deployed no-key guard
+
+import { afterEach, beforeEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { randomBytes } from "crypto";
+import { decryptToken, encryptToken, isEncryptedToken, needsTokenEncryption } from "./crypto";
+
+const originalEnv = { ...process.env };
+beforeEach(() => {
+  delete process.env.token_encryption_key;
+  delete process.env.TOKEN_ENCRYPTION_KEY;
+  delete process.env.K_SERVICE;
+  delete process.env.FUNCTIONS_EMULATOR;
+});
+afterEach(() => {
+  process.env = { ...originalEnv };
+});
+
+test("credentials round-trip through AES-GCM under the enc:v1: prefix", () => {
+  process.env.TOKEN_ENCRYPTION_KEY = randomBytes(32).toString("base64");
+
+  const stored = encryptToken("ya29.secret-access-token");
+  assert.ok(stored.startsWith("enc:v1:"));
+  assert.ok(isEncryptedToken(stored));
+  assert.ok(!stored.includes("ya29.secret-access-token"));
+  assert.notEqual(encryptToken("ya29.secret-access-token"), stored);
+  assert.equal(decryptToken(stored), "ya29.secret-access-token");
+});
+
+test("legacy plaintext values read back unchanged and are flagged for re-encryption", () => {
+  assert.equal(decryptToken("legacy-refresh-token"), "legacy-refresh-token");
+  assert.equal(needsTokenEncryption("legacy-refresh-token"), false);
+
+  process.env.TOKEN_ENCRYPTION_KEY = randomBytes(32).toString("base64");
+  assert.equal(decryptToken("legacy-refresh-token"), "legacy-refresh-token");
+  assert.equal(needsTokenEncryption("legacy-refresh-token"), true);
+  assert.equal(needsTokenEncryption(encryptToken("legacy-refresh-token")), false);
+});
+
+test("a value encrypted under another key or tampered with fails to decrypt", () => {
+  process.env.TOKEN_ENCRYPTION_KEY = randomBytes(32).toString("base64");
+  const stored = encryptToken("refresh-token");
+  // Flip a ciphertext byte - editing the base64 text directly can land on padding bits and change nothing
+  const prefix = stored.slice(0, stored.lastIndexOf(":") + 1);
+  const bytes = Buffer.from(stored.slice(prefix.length), "base64");
+  bytes[bytes.length - 1] ^= 0x01;
+  const tampered = prefix + bytes.toString("base64");
+  assert.throws(() => decryptToken(tampered));
+
+  process.env.TOKEN_ENCRYPTION_KEY = randomBytes(32).toString("base64");
+  assert.throws(() => decryptToken(stored));
+});
+
+test("without a key, local development stores plaintext but a deployed function refuses", () => {
+  assert.equal(encryptToken("dev-token"), "dev-token");
+
+  process.env.K_SERVICE = "oauthexchange";
+  process.env.FUNCTIONS_EMULATOR = "true";
+  assert.equal(encryptToken("dev-token"), "dev-token");
+
+  delete process.env.FUNCTIONS_EMULATOR;
+  assert.throws(() => encryptToken("prod-token"), /not configured/);
+});
+
+test("a key of the wrong length is rejected", () => {
+  process.env.TOKEN_ENCRYPTION_KEY = randomBytes(16).toString("base64");
+  assert.throws(() => encryptToken("token"), /32 bytes/);
+});
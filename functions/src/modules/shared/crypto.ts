@@ -0,0 +1,83 @@
+// At-rest encryption for stored OAuth credentials (AES-256-GCM)
+
+import { createCipheriv, createDecipheriv, randomBytes } from "crypto";
+import * as logger from "firebase-functions/logger";
+import { tokenEncryptionKey } from "../../config";
+
+// Stored form: "enc:v1:" + base64(iv | auth tag | ciphertext). Anything without the prefix is legacy plaintext
+const ENCRYPTED_PREFIX = "enc:v1:";
+const IV_BYTES = 12;
+const TAG_BYTES = 16;
+
+// Helper function to load the 32-byte key (base64) from Secret Manager, or the environment for local development
+function getEncryptionKey(): Buffer | null {
+  let encoded = "";
+  try {
+    encoded = tokenEncryptionKey.value().trim();
+  } catch {
+    // Secret not bound to this function - fall through to the environment
+  }
+  encoded = encoded || (process.env.TOKEN_ENCRYPTION_KEY || "").trim();
+
+  if (!encoded) {
+    return null;
+  }
+
+  const key = Buffer.from(encoded, "base64");
+  if (key.length !== 32) {
+    throw new Error(`Token encryption key must be 32 bytes (base64), got ${key.length}`);
+  }
+  return key;
+}
+
+// Check whether a stored value is already encrypted
+export function isEncryptedToken(stored: string): boolean {
+  return stored.startsWith(ENCRYPTED_PREFIX);
+}
+
+// Helper function to tell a deployed function apart from local development and the emulator
+function isDeployed(): boolean {
+  return Boolean(process.env.K_SERVICE) && process.env.FUNCTIONS_EMULATOR !== "true";
+}
+
+// Encrypt a credential for storage - without a configured key it is stored as-is in local development,
+// and refused outright when deployed so a missing secret binding never writes plaintext credentials
+export function encryptToken(plaintext: string): string {
+  const key = getEncryptionKey();
+  if (!key) {
+    if (isDeployed()) {
+      logger.error("No token encryption key configured, refusing to store credential unencrypted");
+      throw new Error("Token encryption key is not configured");
+    }
+    logger.warn("No token encryption key configured, storing credential unencrypted");
+    return plaintext;
+  }
+
+  const iv = randomBytes(IV_BYTES);
+  const cipher = createCipheriv("aes-256-gcm", key, iv);
+  const ciphertext = Buffer.concat([cipher.update(plaintext, "utf8"), cipher.final()]);
+  return ENCRYPTED_PREFIX + Buffer.concat([iv, cipher.getAuthTag(), ciphertext]).toString("base64");
+}
+
+// Decrypt a stored credential; legacy plaintext values are returned unchanged.
+// Throws if the value was encrypted with a different key or has been tampered with
+export function decryptToken(stored: string): string {
+  if (!isEncryptedToken(stored)) {
+    return stored;
+  }
+
+  const key = getEncryptionKey();
+  if (!key) {
+    throw new Error("Stored credential is encrypted but no token encryption key is configured");
+  }
+
+  const payload = Buffer.from(stored.slice(ENCRYPTED_PREFIX.length), "base64");
+  const decipher = createDecipheriv("aes-256-gcm", key, payload.subarray(0, IV_BYTES));
+  decipher.setAuthTag(payload.subarray(IV_BYTES, IV_BYTES + TAG_BYTES));
+  return Buffer.concat([decipher.update(payload.subarray(IV_BYTES + TAG_BYTES)), decipher.final()]).toString("utf8");
+}
+
+// Check whether a stored value should be rewritten encrypted (plaintext while a key is configured)
+export function needsTokenEncryption(stored: string): boolean {
+  return !isEncryptedToken(stored) && getEncryptionKey() !== null;
+}
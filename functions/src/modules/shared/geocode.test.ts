@@ -0,0 +1,77 @@
+// Forward geocoding cache tests - the weather client is stubbed, cache writes go to the Firestore emulator (npm test)
+
+import { afterEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { HttpsError } from "firebase-functions/v2/https";
+import { CACHE_TTL } from "../../config";
+import { getCachedWeatherData, getGeocodeCacheKey } from "./cache";
+import { weatherHttpClient } from "./http";
+import { geocodeQuery, getDetailedLocation } from "./location";
+
+const originalGet = weatherHttpClient.get;
+afterEach(() => {
+  weatherHttpClient.get = originalGet;
+});
+
+// Helper function to answer every direct geocoding lookup with the same place, counting calls
+function stubDirectGeocoding(): { calls: number } {
+  const counter = { calls: 0 };
+  weatherHttpClient.get = (async () => {
+    counter.calls++;
+    return { status: 200, data: [{ lat: 48.8566, lon: 2.3522 }], headers: {} };
+  }) as unknown as typeof weatherHttpClient.get;
+  return counter;
+}
+
+test("geocode cache keys are hashed, normalized and safe as document IDs", () => {
+  const key = getGeocodeCacheKey("  Paris/Île-de-France ");
+  assert.match(key, /^geocode:[0-9a-f]{64}$/);
+  assert.equal(getGeocodeCacheKey("paris/île-de-france"), key);
+  assert.equal(getGeocodeCacheKey("New  York"), getGeocodeCacheKey("new york"));
+});
+
+test("private lookups are neither cached nor served from the cache", async () => {
+  const counter = stubDirectGeocoding();
+  const query = "Dentist, 12 Rue de Rivoli/2nd floor";
+
+  assert.deepEqual(await geocodeQuery(query, "test-key", { persist: false }), { latitude: 48.8566, longitude: 2.3522 });
+  assert.equal(await getCachedWeatherData(getGeocodeCacheKey(query), CACHE_TTL.GEOCODE), null);
+
+  await geocodeQuery(query, "test-key", { persist: false });
+  assert.equal(counter.calls, 2);
+});
+
+test("public lookups are cached under the hashed key", async () => {
+  const counter = stubDirectGeocoding();
+  const query = "Paris/FR cache test";
+
+  await geocodeQuery(query, "test-key");
+  await geocodeQuery(query, "test-key");
+  assert.equal(counter.calls, 1);
+});
+
+test("geocoding lookups go over HTTPS, since the key is in the query string", async () => {
+  const urls: string[] = [];
+  weatherHttpClient.get = (async (url: string) => {
+    urls.push(url);
+    return { status: 200, data: url.endsWith("/zip") ? { lat: 37.39, lon: -122.08 } : [{ lat: 1, lon: 2, name: "X", country: "US" }], headers: {} };
+  }) as unknown as typeof weatherHttpClient.get;
+
+  await geocodeQuery("94040,us", "test-key", { persist: false });
+  await geocodeQuery("Mountain View", "test-key", { persist: false });
+  await getDetailedLocation(12.3456, 65.4321, "test-key");
+  assert.deepEqual(urls, [
+    "https://api.openweathermap.org/geo/1.0/zip",
+    "https://api.openweathermap.org/geo/1.0/direct",
+    "https://api.openweathermap.org/geo/1.0/reverse",
+  ]);
+});
+
+test("an empty query is invalid-argument and an unknown place is not-found", async () => {
+  weatherHttpClient.get = (async () => ({ status: 200, data: [], headers: {} })) as unknown as typeof weatherHttpClient.get;
+
+  await assert.rejects(geocodeQuery("   ", "test-key"),
+    (error: unknown) => error instanceof HttpsError && error.code === "invalid-argument");
+  await assert.rejects(geocodeQuery("Nowhereville", "test-key", { persist: false }),
+    (error: unknown) => error instanceof HttpsError && error.code === "not-found" && error.message.includes("Nowhereville"));
+});
@@ -0,0 +1,77 @@
+// Outbound client tests against a local HTTP server
+
+import { after, before, beforeEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import * as http from "http";
+import { AddressInfo } from "net";
+import axios from "axios";
+import { HTTP_CONFIG } from "../../config";
+import { createHttpClient } from "./http";
+
+let server: http.Server;
+let baseUrl: string;
+let hits: { [path: string]: number } = {};
+
+before(async () => {
+  server = http.createServer((request, response) => {
+    const path = request.url || "/";
+    hits[path] = (hits[path] || 0) + 1;
+
+    if (path === "/slow") {
+      setTimeout(() => response.end("late"), 500);
+    } else if (path === "/flaky" && hits[path] === 1) {
+      response.statusCode = 503;
+      response.end("unavailable");
+    } else if (path === "/down" || path === "/post-down") {
+      response.statusCode = 503;
+      response.end("unavailable");
+    } else if (path === "/missing") {
+      response.statusCode = 404;
+      response.end("not found");
+    } else {
+      response.end("ok");
+    }
+  });
+  await new Promise<void>((resolve) => server.listen(0, "127.0.0.1", resolve));
+  baseUrl = `http://127.0.0.1:${(server.address() as AddressInfo).port}`;
+});
+
+after(() => {
+  server.closeAllConnections();
+  server.close();
+});
+
+beforeEach(() => {
+  hits = {};
+});
+
+test("createHttpClient applies the default timeout when none is given", () => {
+  assert.equal(createHttpClient().defaults.timeout, HTTP_CONFIG.TIMEOUT_MS);
+  assert.equal(createHttpClient({ timeout: 1234 }).defaults.timeout, 1234);
+});
+
+test("a stalled upstream times out", async () => {
+  const client = createHttpClient({ timeout: 100, retries: 0 });
+  await assert.rejects(client.get(`${baseUrl}/slow`),
+    (error: unknown) => axios.isAxiosError(error) && error.code === "ECONNABORTED");
+});
+
+test("a 5xx on a GET is retried", async () => {
+  const response = await createHttpClient({ retries: 2 }).get(`${baseUrl}/flaky`);
+  assert.equal(response.data, "ok");
+  assert.equal(hits["/flaky"], 2);
+});
+
+test("retries stop at the configured count", async () => {
+  await assert.rejects(createHttpClient({ retries: 2 }).get(`${baseUrl}/down`));
+  assert.equal(hits["/down"], 3);
+});
+
+test("4xx responses and non-idempotent requests are not retried", async () => {
+  const client = createHttpClient({ retries: 2 });
+  await assert.rejects(client.get(`${baseUrl}/missing`));
+  assert.equal(hits["/missing"], 1);
+
+  await assert.rejects(client.post(`${baseUrl}/post-down`, {}));
+  assert.equal(hits["/post-down"], 1);
+});
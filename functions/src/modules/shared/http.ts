@@ -0,0 +1,93 @@
+// Outbound HTTP utilities
+
+import * as fs from "fs";
+import * as https from "https";
+import * as tls from "tls";
+import { X509Certificate } from "crypto";
+import axios, { AxiosError, AxiosInstance, InternalAxiosRequestConfig } from "axios";
+import * as logger from "firebase-functions/logger";
+import { HTTP_CONFIG, WEATHER_CONFIG } from "../../config";
+
+// Helper function to load a PEM CA bundle, failing loudly if any certificate doesn't parse
+function loadCaBundle(bundlePath: string): string[] {
+  const pem = fs.readFileSync(bundlePath, "utf8");
+  const certs = pem.match(/-----BEGIN CERTIFICATE-----[\s\S]+?-----END CERTIFICATE-----/g) || [];
+
+  if (certs.length === 0) {
+    throw new Error(`CA bundle ${bundlePath} contains no PEM certificates`);
+  }
+
+  certs.forEach((cert, index) => {
+    try {
+      new X509Certificate(cert);
+    } catch (error) {
+      throw new Error(`CA bundle ${bundlePath} certificate #${index + 1} is invalid: ${error instanceof Error ? error.message : "Unknown error"}`);
+    }
+  });
+
+  logger.info(`Loaded ${certs.length} certificates from custom CA bundle ${bundlePath}`);
+  return certs;
+}
+
+// Shared HTTPS agent - custom CAs are appended to the system roots, not substituted for them
+export const httpsAgent = new https.Agent({
+  keepAlive: true,
+  ...(HTTP_CONFIG.CA_BUNDLE_PATH && {
+    ca: [...tls.rootCertificates, ...loadCaBundle(HTTP_CONFIG.CA_BUNDLE_PATH)],
+  }),
+});
+
+interface HttpClientOptions {
+  timeout?: number;
+  maxContentLength?: number;
+  retries?: number;
+}
+
+// Helper function to decide whether a failed request is worth repeating
+function isRetryable(error: AxiosError): boolean {
+  const method = (error.config?.method || "get").toUpperCase();
+  if (method !== "GET" && method !== "HEAD") {
+    return false;
+  }
+  // No response means a network error or timeout; 5xx means the upstream failed, not the request
+  return !error.response || error.response.status >= 500;
+}
+
+// Build an outbound client - every client shares the agent (CA bundle), user agent, body cap, timeout and retry policy.
+// Proxies come from the standard HTTPS_PROXY / NO_PROXY environment variables, which axios honors
+export function createHttpClient(options: HttpClientOptions = {}): AxiosInstance {
+  const client = axios.create({
+    httpsAgent,
+    timeout: options.timeout ?? HTTP_CONFIG.TIMEOUT_MS,
+    maxContentLength: options.maxContentLength ?? HTTP_CONFIG.MAX_RESPONSE_BYTES,
+    headers: { "User-Agent": HTTP_CONFIG.USER_AGENT },
+  });
+  const retries = options.retries ?? HTTP_CONFIG.MAX_RETRIES;
+
+  client.interceptors.response.use(undefined, async (error) => {
+    const config = error.config as (InternalAxiosRequestConfig & { retryCount?: number }) | undefined;
+    if (!config || !axios.isAxiosError(error) || !isRetryable(error)) {
+      throw error;
+    }
+
+    const attempt = (config.retryCount || 0) + 1;
+    if (attempt > retries) {
+      throw error;
+    }
+    config.retryCount = attempt;
+
+    // Exponential backoff with jitter: ~base, ~2x base, ~4x base...
+    const delay = HTTP_CONFIG.RETRY_BASE_DELAY_MS * 2 ** (attempt - 1) * (0.5 + Math.random());
+    logger.warn(`Retrying ${config.method?.toUpperCase()} ${config.url} (attempt ${attempt} of ${retries}) after ${Math.round(delay)}ms`);
+    await new Promise((resolve) => setTimeout(resolve, delay));
+    return client.request(config);
+  });
+
+  return client;
+}
+
+// Shared client for other outbound calls (calendar feeds), bounded by the default timeout
+export const httpClient = createHttpClient();
+
+// Client for OpenWeatherMap weather and geocoding calls, bounded by the weather timeout
+export const weatherHttpClient = createHttpClient({ timeout: WEATHER_CONFIG.TIMEOUT_MS });
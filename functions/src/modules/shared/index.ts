@@ -2,3 +2,10 @@
 
 export * from "./cache";
 export * from "./location";
+export * from "./http";
+export * from "./response";
+export * from "./ssrf";
+export * from "./units";
+export * from "./auth";
+export * from "./crypto";
+export * from "./requestContext";
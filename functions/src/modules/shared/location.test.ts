@@ -0,0 +1,34 @@
+// Coordinate validation tests - every failure is an invalid-argument HttpsError so callables answer 400
+
+import { test } from "node:test";
+import * as assert from "node:assert/strict";
+import { HttpsError } from "firebase-functions/v2/https";
+import { validateCoordinates } from "./location";
+
+// Helper function to match an invalid-argument error whose message contains the given text
+function invalidArgument(text: string) {
+  return (error: unknown) => error instanceof HttpsError && error.code === "invalid-argument" && error.message.includes(text);
+}
+
+test("in-range coordinates pass, including the boundaries", () => {
+  validateCoordinates(0, 0);
+  validateCoordinates(90, 180);
+  validateCoordinates(-90, -180);
+});
+
+test("missing or non-numeric coordinates are invalid-argument", () => {
+  assert.throws(() => validateCoordinates(undefined, 10), invalidArgument("required"));
+  assert.throws(() => validateCoordinates("51.5", -0.12), invalidArgument("required"));
+  assert.throws(() => validateCoordinates(NaN, 0), invalidArgument("required"));
+  assert.throws(() => validateCoordinates(0, Infinity), invalidArgument("required"));
+});
+
+test("an out-of-range latitude is invalid-argument and hints at a swap when the longitude fits", () => {
+  assert.throws(() => validateCoordinates(-122.4, 37.8), invalidArgument("may be swapped"));
+  assert.throws(() => validateCoordinates(120, 150),
+    (error: unknown) => error instanceof HttpsError && error.code === "invalid-argument" && !error.message.includes("swapped"));
+});
+
+test("an out-of-range longitude is invalid-argument", () => {
+  assert.throws(() => validateCoordinates(10, 181), invalidArgument("Longitude must be between -180 and 180"));
+});
@@ -1,15 +1,101 @@
 // Location utilities
 
 import * as logger from "firebase-functions/logger";
-import axios from "axios";
-import { getLocationCacheKey, getCachedWeatherData, setCachedWeatherData } from "./cache";
+import { HttpsError } from "firebase-functions/v2/https";
+import { weatherHttpClient } from "./http";
+import { getLocationCacheKey, getGeocodeCacheKey, getCacheGeneration, getCachedWeatherData, setCachedWeatherData } from "./cache";
 import { CACHE_TTL } from "../../config";
 
+// Helper function to validate coordinates (shared by all weather endpoints) - failures are invalid-argument, so
+// callables return a 400 with the message rather than an opaque INTERNAL
+export function validateCoordinates(latitude: unknown, longitude: unknown): void {
+  if (typeof latitude !== "number" || typeof longitude !== "number" ||
+      !Number.isFinite(latitude) || !Number.isFinite(longitude)) {
+    throw new HttpsError("invalid-argument", "Latitude and longitude are required");
+  }
+
+  if (latitude < -90 || latitude > 90) {
+    // A longitude-sized latitude alongside a latitude-sized longitude is almost always a swap
+    const hint = Math.abs(longitude) <= 90 ? " (latitude and longitude may be swapped)" : "";
+    throw new HttpsError("invalid-argument", `Latitude must be between -90 and 90, got ${latitude}${hint}`);
+  }
+
+  if (longitude < -180 || longitude > 180) {
+    throw new HttpsError("invalid-argument", `Longitude must be between -180 and 180, got ${longitude}`);
+  }
+}
+
+// OpenWeatherMap geocoding - always over HTTPS, since the API key travels in the query string
+const GEOCODING_URL = "https://api.openweathermap.org/geo/1.0";
+
+// ZIP lookups look like "94040" or "94040,us"; anything else is treated as a city name
+const ZIP_QUERY_PATTERN = /^\d{5}(-\d{4})?(,\s*[a-z]{2})?$/i;
+
+// Resolve a city name or ZIP code (e.g. "London", "94040,us") to coordinates using forward geocoding.
+// Pass persist: false for text that's the user's private data (calendar event locations) - it's then neither
+// read from nor written to the shared cache
+export async function geocodeQuery(
+  query: string,
+  apiKey: string,
+  options: { persist?: boolean } = {}
+): Promise<{latitude: number; longitude: number}> {
+  const trimmedQuery = query.trim();
+  if (!trimmedQuery) {
+    throw new HttpsError("invalid-argument", "Location query must not be empty");
+  }
+
+  const persist = options.persist !== false;
+  const geocodeCacheKey = getGeocodeCacheKey(trimmedQuery);
+  const cachedCoordinates = persist ? await getCachedWeatherData(geocodeCacheKey, CACHE_TTL.GEOCODE) : null;
+
+  if (cachedCoordinates) {
+    logger.info(`Cache hit (geocode): ${geocodeCacheKey}`);
+    return JSON.parse(cachedCoordinates as string);
+  }
+
+  let latitude: number | undefined;
+  let longitude: number | undefined;
+
+  if (ZIP_QUERY_PATTERN.test(trimmedQuery)) {
+    // The ZIP endpoint defaults to the US when no country code is given
+    const response = await weatherHttpClient.get(`${GEOCODING_URL}/zip`, {
+      params: { zip: trimmedQuery.replace(/\s+/g, ""), appid: apiKey },
+      validateStatus: (status) => status === 200 || status === 404,
+    });
+    if (response.status === 200 && response.data) {
+      latitude = response.data.lat;
+      longitude = response.data.lon;
+    }
+  } else {
+    const response = await weatherHttpClient.get(`${GEOCODING_URL}/direct`, {
+      params: { q: trimmedQuery, limit: 1, appid: apiKey },
+    });
+    if (Array.isArray(response.data) && response.data.length > 0) {
+      latitude = response.data[0].lat;
+      longitude = response.data[0].lon;
+    }
+  }
+
+  if (typeof latitude !== "number" || typeof longitude !== "number") {
+    throw new HttpsError("not-found", `No location found for "${trimmedQuery}"`);
+  }
+
+  const coordinates = { latitude, longitude };
+  if (!persist) {
+    return coordinates;
+  }
+  await setCachedWeatherData(geocodeCacheKey, JSON.stringify(coordinates), CACHE_TTL.GEOCODE);
+
+  logger.info(`Geocoded "${trimmedQuery}" to ${latitude}, ${longitude}`);
+  return coordinates;
+}
+
 // Helper function to get detailed location information using reverse geocoding
 export async function getDetailedLocation(latitude: number, longitude: number, apiKey: string): Promise<string> {
   // Check cache first
   const locationCacheKey = getLocationCacheKey(latitude, longitude);
-  const cachedLocation = await getCachedWeatherData(locationCacheKey, CACHE_TTL.LOCATION);
+  const cacheGeneration = getCacheGeneration(locationCacheKey);
+  const cachedLocation = await getCachedWeatherData(locationCacheKey, CACHE_TTL.GEOCODE);
   
   if (cachedLocation) {
     logger.info(`Cache hit (location): ${locationCacheKey}`);
@@ -18,7 +104,7 @@ export async function getDetailedLocation(latitude: number, longitude: number, a
 
   try {
     // Use OpenWeatherMap's reverse geocoding API
-    const url = "http://api.openweathermap.org/geo/1.0/reverse";
+    const url = `${GEOCODING_URL}/reverse`;
     const params = {
       lat: latitude,
       lon: longitude,
@@ -26,7 +112,7 @@ export async function getDetailedLocation(latitude: number, longitude: number, a
       appid: apiKey,
     };
 
-    const response = await axios.get(url, {params});
+    const response = await weatherHttpClient.get(url, {params});
     const data = response.data;
 
     if (data && data.length > 0) {
@@ -44,7 +130,7 @@ export async function getDetailedLocation(latitude: number, longitude: number, a
       const detailedLocation = parts.join(", ");
       
       // Cache the location data
-      await setCachedWeatherData(locationCacheKey, detailedLocation, CACHE_TTL.LOCATION);
+      await setCachedWeatherData(locationCacheKey, detailedLocation, CACHE_TTL.GEOCODE, cacheGeneration);
       
       logger.info(`Cached location data: ${detailedLocation}`);
       return detailedLocation;
@@ -53,11 +139,6 @@ export async function getDetailedLocation(latitude: number, longitude: number, a
     logger.warn("Reverse geocoding failed:", error);
   }
   
-  // Fallback to basic location format
-  const fallbackLocation = `Lat: ${latitude.toFixed(2)}, Lon: ${longitude.toFixed(2)}`;
-  
-  // Cache the fallback as well (shorter TTL)
-  await setCachedWeatherData(locationCacheKey, fallbackLocation, CACHE_TTL.LOCATION);
-  
-  return fallbackLocation;
+  // Fallback to basic location format - not cached, so a transient failure doesn't pin it for a whole geocode TTL
+  return `Lat: ${latitude.toFixed(2)}, Lon: ${longitude.toFixed(2)}`;
 }
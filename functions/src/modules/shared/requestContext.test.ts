@@ -0,0 +1,42 @@
+// Request context tests - each invocation gets its own request ID and memo, even run back to back
+
+import { test } from "node:test";
+import * as assert from "node:assert/strict";
+import type { Request } from "firebase-functions/v2/https";
+import { getRequestId, memoizeForRequest, runWithRequestContext } from "./requestContext";
+
+// Helper function to build the slice of a request the context reads (no response, so no access log)
+function fakeRequest(headers: { [name: string]: string } = {}): Request {
+  return { headers } as unknown as Request;
+}
+
+test("sequential requests on the same instance don't share the memo or request ID", async () => {
+  let lookups = 0;
+  const lookup = () => memoizeForRequest("current:same-key", async () => ++lookups);
+
+  const first = await runWithRequestContext(fakeRequest({ "x-request-id": "req-one" }), async () => {
+    assert.equal(getRequestId(), "req-one");
+    return [await lookup(), await lookup()];
+  });
+  assert.deepEqual(first, [1, 1]);
+  assert.equal(getRequestId(), null);
+
+  const second = await runWithRequestContext(fakeRequest(), async () => {
+    assert.notEqual(getRequestId(), "req-one");
+    return await lookup();
+  });
+  assert.equal(second, 2);
+});
+
+test("a malformed caller request ID is replaced rather than propagated", () => {
+  runWithRequestContext(fakeRequest({ "x-request-id": "bad id\ninjected" }), () => {
+    assert.match(getRequestId() || "", /^[0-9a-f-]{36}$/);
+  });
+});
+
+test("lookups outside a request context always run", async () => {
+  let lookups = 0;
+  await memoizeForRequest("outside", async () => ++lookups);
+  await memoizeForRequest("outside", async () => ++lookups);
+  assert.equal(lookups, 2);
+});
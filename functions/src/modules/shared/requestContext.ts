@@ -0,0 +1,90 @@
+// Per-request context - a request ID and memo that follow the invocation through every await, and one access log
+// line per request
+
+import { AsyncLocalStorage } from "async_hooks";
+import { randomUUID } from "crypto";
+import type { Request } from "firebase-functions/v2/https";
+import * as logger from "firebase-functions/logger";
+import { LOG_CONFIG } from "../../config";
+
+interface RequestContext {
+  requestId: string;
+  memo: Map<string, Promise<unknown>>; // Results shared by identical lookups within this request
+}
+
+const requestContext = new AsyncLocalStorage<RequestContext>();
+
+// Caller-supplied IDs are propagated only when they look like an ID, so the header can't inject into logs
+const REQUEST_ID_PATTERN = /^[A-Za-z0-9._:-]{1,128}$/;
+
+// Helper function to reuse the caller's X-Request-ID or mint a new one
+function resolveRequestId(header: string | string[] | undefined): string {
+  const value = Array.isArray(header) ? header[0] : header;
+  return value && REQUEST_ID_PATTERN.test(value) ? value : randomUUID();
+}
+
+// Helper function to build the context for an invocation: resolves the request ID, echoes it in the
+// X-Request-ID response header and logs method, path, status, latency and client IP once the response is sent
+function createRequestContext(request: Request): RequestContext {
+  const requestId = resolveRequestId(request.headers["x-request-id"]);
+  const startedAt = Date.now();
+  const memo = new Map<string, Promise<unknown>>();
+
+  const response = request.res;
+  if (response) {
+    response.set("X-Request-ID", requestId);
+    response.on("finish", () => {
+      memo.clear();
+      const entry = {
+        requestId,
+        method: request.method,
+        path: request.path,
+        status: response.statusCode,
+        latencyMs: Date.now() - startedAt,
+        clientIp: request.ip,
+      };
+      if (LOG_CONFIG.FORMAT === "text") {
+        logger.info(`${entry.method} ${entry.path} ${entry.status} ${entry.latencyMs}ms ${entry.clientIp} [${requestId}]`);
+      } else {
+        logger.info("request", entry);
+      }
+    });
+  }
+
+  return { requestId, memo };
+}
+
+// Run a handler inside its own request context - the context is scoped to the handler's async chain, so its
+// memo and request ID never carry over to the next invocation on the same instance.
+// Wrap the whole handler body - callables pass request.rawRequest
+export function runWithRequestContext<R>(request: Request, handler: () => R): R {
+  return requestContext.run(createRequestContext(request), handler);
+}
+
+// The current invocation's request ID, or null outside a request (scheduled jobs)
+export function getRequestId(): string | null {
+  return requestContext.getStore()?.requestId ?? null;
+}
+
+// Run a lookup once per request: identical keys within the same invocation share the first call's promise
+// (including its failure). Outside a request context the lookup just runs
+export function memoizeForRequest<T>(key: string, lookup: () => Promise<T>): Promise<T> {
+  const memo = requestContext.getStore()?.memo;
+  if (!memo) {
+    return lookup();
+  }
+
+  const existing = memo.get(key);
+  if (existing) {
+    return existing as Promise<T>;
+  }
+  const pending = lookup();
+  memo.set(key, pending);
+  return pending;
+}
+
+// Structured fields for log calls - pass as the last logger argument so the entry can be correlated
+export function requestLogFields(): { requestId?: string } {
+  const requestId = getRequestId();
+  return requestId ? { requestId } : {};
+}
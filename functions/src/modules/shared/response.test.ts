@@ -0,0 +1,107 @@
+// onRequest response helper tests with minimal request/response doubles
+
+import { afterEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import type { Request } from "firebase-functions/v2/https";
+import type { Response } from "express";
+import { CORS_CONFIG } from "../../config";
+import { handleCors, handleUnknownPath, sendMethodNotAllowed } from "./response";
+
+// Helper function to build a response double that records status, headers and body
+function recordingResponse() {
+  const recorded: { status?: number; body?: unknown; headers: { [name: string]: string } } = { headers: {} };
+  const response = {
+    status(code: number) {
+      recorded.status = code;
+      return response;
+    },
+    json(body: unknown) {
+      recorded.body = body;
+      return response;
+    },
+    send(body: unknown) {
+      recorded.body = body;
+      return response;
+    },
+    set(name: string, value: string) {
+      recorded.headers[name] = value;
+      return response;
+    },
+  };
+  return { response: response as unknown as Response, recorded };
+}
+
+test("the endpoint root is left to the handler", () => {
+  for (const path of ["/", ""]) {
+    const { response, recorded } = recordingResponse();
+    assert.equal(handleUnknownPath({ path } as Request, response), false);
+    assert.equal(recorded.status, undefined);
+  }
+});
+
+test("unknown sub-paths get a JSON 404", () => {
+  for (const path of ["/typo", "/locations/123", "//"]) {
+    const { response, recorded } = recordingResponse();
+    assert.equal(handleUnknownPath({ path } as Request, response), true, path);
+    assert.equal(recorded.status, 404);
+    assert.deepEqual(recorded.body, { success: false, error: "Not found" });
+  }
+});
+
+test("405s carry the Allow header and the standard envelope", () => {
+  const { response, recorded } = recordingResponse();
+  sendMethodNotAllowed(response, ["GET", "OPTIONS"]);
+  assert.equal(recorded.status, 405);
+  assert.equal(recorded.headers.Allow, "GET, OPTIONS");
+  assert.deepEqual(recorded.body, { success: false, error: "Method not allowed" });
+});
+
+const configuredOrigins = CORS_CONFIG.ALLOWED_ORIGINS;
+
+afterEach(() => {
+  CORS_CONFIG.ALLOWED_ORIGINS = configuredOrigins;
+});
+
+// Helper function to build a request double from a method and an optional Origin header
+function corsRequest(method: string, origin?: string): Request {
+  return { method, headers: origin ? { origin } : {} } as unknown as Request;
+}
+
+test("a wildcard allowlist answers every origin with * and no credentials", () => {
+  CORS_CONFIG.ALLOWED_ORIGINS = ["*"];
+  const { response, recorded } = recordingResponse();
+  assert.equal(handleCors(corsRequest("GET", "https://anywhere.example"), response, ["GET", "OPTIONS"]), false);
+  assert.equal(recorded.headers["Access-Control-Allow-Origin"], "*");
+  assert.equal(recorded.headers["Access-Control-Allow-Credentials"], undefined);
+  assert.equal(recorded.headers["Access-Control-Allow-Methods"], "GET, OPTIONS");
+  assert.equal(recorded.headers["Access-Control-Expose-Headers"], "X-Request-ID");
+});
+
+test("an allowlisted origin is echoed back with credentials", () => {
+  CORS_CONFIG.ALLOWED_ORIGINS = ["https://scott-weather-service.web.app", "http://localhost:3000"];
+  const { response, recorded } = recordingResponse();
+  handleCors(corsRequest("GET", "http://localhost:3000"), response, ["GET"]);
+  assert.equal(recorded.headers["Access-Control-Allow-Origin"], "http://localhost:3000");
+  assert.equal(recorded.headers["Access-Control-Allow-Credentials"], "true");
+  assert.equal(recorded.headers.Vary, "Origin");
+});
+
+test("origins outside the allowlist get no Allow-Origin header", () => {
+  CORS_CONFIG.ALLOWED_ORIGINS = ["https://scott-weather-service.web.app"];
+  for (const origin of ["https://evil.example", "https://scott-weather-service.web.app.evil.example", undefined]) {
+    const { response, recorded } = recordingResponse();
+    handleCors(corsRequest("GET", origin), response, ["GET"]);
+    assert.equal(recorded.headers["Access-Control-Allow-Origin"], undefined, String(origin));
+    assert.equal(recorded.headers["Access-Control-Allow-Credentials"], undefined, String(origin));
+    assert.equal(recorded.headers.Vary, "Origin");
+  }
+});
+
+test("preflights are answered with a 204 and stop the handler", () => {
+  CORS_CONFIG.ALLOWED_ORIGINS = ["https://scott-weather-service.web.app"];
+  const { response, recorded } = recordingResponse();
+  assert.equal(handleCors(corsRequest("OPTIONS", "https://scott-weather-service.web.app"), response, ["GET", "OPTIONS"]), true);
+  assert.equal(recorded.status, 204);
+  assert.equal(recorded.headers["Access-Control-Allow-Origin"], "https://scott-weather-service.web.app");
+  assert.equal(recorded.headers["Access-Control-Allow-Headers"], "Content-Type, Authorization, X-Request-ID");
+});
@@ -0,0 +1,46 @@
+// Response helpers for onRequest endpoints
+
+import type { Request } from "firebase-functions/v2/https";
+import type { Response } from "express";
+import { CORS_CONFIG } from "../../config";
+
+// Reject an unsupported method with the standard error envelope and an Allow header
+export function sendMethodNotAllowed(response: Response, allowedMethods: string[]): void {
+  response.set("Allow", allowedMethods.join(", "));
+  response.status(405).json({ success: false, error: "Method not allowed" });
+}
+
+// Reject anything below the function's root path (e.g. /savedLocations/typo) with the standard error envelope.
+// Returns true when the request has been answered - every endpoint serves only its root
+export function handleUnknownPath(request: Request, response: Response): boolean {
+  if (request.path === "/" || request.path === "") {
+    return false;
+  }
+  response.status(404).json({ success: false, error: "Not found" });
+  return true;
+}
+
+// Set CORS headers for the configured origin allowlist and answer preflights.
+// Returns true when the request was an OPTIONS preflight and has been handled
+export function handleCors(request: Request, response: Response, allowedMethods: string[]): boolean {
+  const origin = request.headers.origin;
+  if (CORS_CONFIG.ALLOWED_ORIGINS.includes("*")) {
+    response.set("Access-Control-Allow-Origin", "*");
+  } else if (origin && CORS_CONFIG.ALLOWED_ORIGINS.includes(origin)) {
+    // Credentials are only allowed alongside a specific echoed origin, never "*"
+    response.set("Access-Control-Allow-Origin", origin);
+    response.set("Access-Control-Allow-Credentials", "true");
+    response.set("Vary", "Origin");
+  } else {
+    response.set("Vary", "Origin");
+  }
+  response.set("Access-Control-Allow-Methods", allowedMethods.join(", "));
+  response.set("Access-Control-Allow-Headers", CORS_CONFIG.ALLOWED_HEADERS);
+  response.set("Access-Control-Expose-Headers", CORS_CONFIG.EXPOSED_HEADERS);
+
+  if (request.method === "OPTIONS") {
+    response.status(204).send("");
+    return true;
+  }
+  return false;
+}
@@ -0,0 +1,85 @@
+// SSRF guard tests - DNS is stubbed, so hostnames resolve to whatever each case needs
+
+import { afterEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import * as dns from "dns";
+import { isNonPublicAddress, safeLookup, userUrlRequestConfig } from "./ssrf";
+
+const originalLookup = dns.promises.lookup;
+afterEach(() => {
+  dns.promises.lookup = originalLookup;
+});
+
+// Helper function to make every hostname resolve to the given addresses
+function resolveTo(...addresses: string[]): void {
+  dns.promises.lookup = (async () =>
+    addresses.map((address) => ({ address, family: address.includes(":") ? 6 : 4 }))) as unknown as typeof dns.promises.lookup;
+}
+
+const blocked: Array<[string, string]> = [
+  ["IPv4 loopback", "127.0.0.1"],
+  ["IPv6 loopback", "::1"],
+  ["RFC 1918 10/8", "10.1.2.3"],
+  ["RFC 1918 172.16/12", "172.20.0.5"],
+  ["RFC 1918 192.168/16", "192.168.1.1"],
+  ["metadata server", "169.254.169.254"],
+  ["carrier-grade NAT", "100.64.0.1"],
+  ["unspecified", "0.0.0.0"],
+  ["IPv4-mapped loopback", "::ffff:127.0.0.1"],
+  ["IPv4-mapped private, hex form", "::ffff:a00:1"],
+  ["IPv4-mapped metadata server", "::ffff:169.254.169.254"],
+  ["NAT64 of a private address", "64:ff9b::a00:1"],
+  ["6to4 of a private address", "2002:a00:1::1"],
+  ["IPv6 unique local", "fd00::1"],
+  ["IPv6 link-local", "fe80::1"],
+];
+
+const allowed: Array<[string, string]> = [
+  ["public IPv4", "93.184.216.34"],
+  ["public IPv6", "2606:2800:220:1:248:1893:25c8:1946"],
+  ["IPv4-mapped public", "::ffff:93.184.216.34"],
+];
+
+test("safeLookup refuses hostnames resolving to non-public addresses", async () => {
+  for (const [name, address] of blocked) {
+    resolveTo(address);
+    await assert.rejects(safeLookup("feed.example.com", {}), /non-public address/, name);
+  }
+});
+
+test("safeLookup refuses a hostname when any of its addresses is non-public", async () => {
+  resolveTo("93.184.216.34", "10.0.0.1");
+  await assert.rejects(safeLookup("feed.example.com", {}), /non-public address 10\.0\.0\.1/);
+});
+
+test("safeLookup passes public addresses through", async () => {
+  for (const [name, address] of allowed) {
+    resolveTo(address);
+    const [addresses] = await safeLookup("feed.example.com", {});
+    assert.deepEqual(addresses.map((entry) => entry.address), [address], name);
+  }
+});
+
+test("isNonPublicAddress refuses anything that isn't an IP address", () => {
+  assert.equal(isNonPublicAddress("localhost"), true);
+  assert.equal(isNonPublicAddress(""), true);
+});
+
+test("redirects into private ranges and non-HTTP schemes are refused", () => {
+  const beforeRedirect = userUrlRequestConfig.beforeRedirect as (options: Record<string, unknown>, response: unknown) => void;
+  const refused: Array<[string, Record<string, unknown>]> = [
+    ["loopback", { protocol: "http:", hostname: "127.0.0.1" }],
+    ["RFC 1918", { protocol: "https:", hostname: "192.168.0.10" }],
+    ["metadata server", { protocol: "http:", hostname: "169.254.169.254" }],
+    ["bracketed IPv6 loopback", { protocol: "http:", hostname: "[::1]" }],
+    ["IPv4-mapped IPv6", { protocol: "http:", hostname: "[::ffff:10.0.0.1]" }],
+    ["file scheme", { protocol: "file:", hostname: "" }],
+  ];
+
+  for (const [name, options] of refused) {
+    assert.throws(() => beforeRedirect(options, {}), /Refusing to fetch/, name);
+  }
+  // Hostname targets are left to safeLookup, which runs for the redirected request's connection
+  assert.doesNotThrow(() => beforeRedirect({ protocol: "https:", hostname: "calendar.example.com" }, {}));
+  assert.doesNotThrow(() => beforeRedirect({ protocol: "https:", hostname: "93.184.216.34" }, {}));
+});
@@ -0,0 +1,93 @@
+// SSRF protection for user-supplied URLs (ICS feeds and anything else fetched on a user's behalf)
+
+import * as dns from "dns";
+import * as net from "net";
+import type { AxiosRequestConfig } from "axios";
+
+// Address ranges we never connect to on a user's behalf
+const BLOCKED_IPV4_RANGES: [string, number][] = [
+  ["0.0.0.0", 8], // "This" network
+  ["10.0.0.0", 8], // Private
+  ["100.64.0.0", 10], // Carrier-grade NAT
+  ["127.0.0.0", 8], // Loopback
+  ["169.254.0.0", 16], // Link-local (includes the metadata server)
+  ["172.16.0.0", 12], // Private
+  ["192.0.0.0", 24], // IETF protocol assignments
+  ["192.168.0.0", 16], // Private
+  ["198.18.0.0", 15], // Benchmarking
+  ["224.0.0.0", 3], // Multicast and reserved
+];
+
+const BLOCKED_IPV6_RANGES: [string, number][] = [
+  ["::", 128], // Unspecified
+  ["::1", 128], // Loopback
+  ["64:ff9b::", 96], // NAT64 - embeds an IPv4 address, which may well be a private one
+  ["2002::", 16], // 6to4 - likewise embeds an IPv4 address
+  ["fc00::", 7], // Unique local
+  ["fe80::", 10], // Link-local
+  ["ff00::", 8], // Multicast
+];
+
+const blockList = new net.BlockList();
+BLOCKED_IPV4_RANGES.forEach(([network, prefix]) => blockList.addSubnet(network, prefix, "ipv4"));
+BLOCKED_IPV6_RANGES.forEach(([network, prefix]) => blockList.addSubnet(network, prefix, "ipv6"));
+
+// Helper function to check whether an IP address is private, loopback, link-local, or otherwise non-public
+export function isNonPublicAddress(address: string): boolean {
+  // IPv4-mapped IPv6 (::ffff:10.0.0.1) must be judged as the IPv4 address it maps to
+  const mapped = address.toLowerCase().match(/^::ffff:(\d+\.\d+\.\d+\.\d+)$/);
+  if (mapped) {
+    return isNonPublicAddress(mapped[1]);
+  }
+
+  if (net.isIPv4(address)) {
+    return blockList.check(address, "ipv4");
+  }
+  if (net.isIPv6(address)) {
+    return blockList.check(address, "ipv6");
+  }
+  return true; // Not an IP address at all - refuse rather than guess
+}
+
+// Helper function to reject a scheme or literal-IP host we must not connect to
+function assertSafeTarget(protocol: string, hostname: string): void {
+  if (protocol !== "https:" && protocol !== "http:") {
+    throw new Error(`Refusing to fetch non-HTTP URL scheme ${protocol}`);
+  }
+
+  const host = hostname.replace(/^\[|\]$/g, "");
+  if (net.isIP(host) && isNonPublicAddress(host)) {
+    throw new Error(`Refusing to fetch non-public address ${host}`);
+  }
+}
+
+// DNS lookup that refuses non-public addresses - checked at connect time, so DNS rebinding can't slip past validation
+export async function safeLookup(hostname: string, _options: object): Promise<[{ address: string; family: 4 | 6 }[]]> {
+  const addresses = await dns.promises.lookup(hostname, { all: true });
+  const blocked = addresses.find((entry) => isNonPublicAddress(entry.address));
+  if (blocked) {
+    throw new Error(`Refusing to fetch ${hostname}: resolves to non-public address ${blocked.address}`);
+  }
+  return [addresses.map((entry) => ({ address: entry.address, family: entry.family === 6 ? 6 : 4 }))];
+}
+
+// Validate a user-supplied URL up front (scheme plus resolved addresses) for early, clear errors
+export async function validateUserUrl(rawUrl: string): Promise<string> {
+  const url = new URL(rawUrl);
+  assertSafeTarget(url.protocol, url.hostname);
+
+  if (!net.isIP(url.hostname.replace(/^\[|\]$/g, ""))) {
+    await safeLookup(url.hostname, {});
+  }
+  return url.toString();
+}
+
+// Request config for every fetch of a user-supplied URL - guards each redirect hop as well as the first request
+export const userUrlRequestConfig: Pick<AxiosRequestConfig, "lookup" | "beforeRedirect" | "maxRedirects"> = {
+  lookup: safeLookup,
+  maxRedirects: 5,
+  beforeRedirect: (options: Record<string, unknown>) => {
+    // Literal-IP redirect targets never reach the lookup, so check them here
+    assertSafeTarget(String(options.protocol), String(options.hostname));
+  },
+};
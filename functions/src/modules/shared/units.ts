@@ -0,0 +1,78 @@
+// Unit system handling - the single source of truth for accepted "units" values
+
+import * as logger from "firebase-functions/logger";
+import { HttpsError } from "firebase-functions/v2/https";
+import { db } from "../../config";
+
+export type Units = "metric" | "imperial";
+
+export const DEFAULT_UNITS: Units = "metric";
+
+// Spellings clients and stored profiles use, mapped to the unit system they mean
+const UNIT_ALIASES: { [alias: string]: Units } = {
+  metric: "metric",
+  celsius: "metric",
+  c: "metric",
+  si: "metric",
+  imperial: "imperial",
+  fahrenheit: "imperial",
+  f: "imperial",
+  us: "imperial",
+};
+
+// Normalize a units value (case-insensitive, with aliases), defaulting when it's absent
+export function normalizeUnits(value: unknown): Units {
+  if (value === undefined || value === null || value === "") {
+    return DEFAULT_UNITS;
+  }
+
+  const units = typeof value === "string" ? UNIT_ALIASES[value.trim().toLowerCase()] : undefined;
+  if (!units) {
+    throw new HttpsError("invalid-argument", `units must be "metric" or "imperial", got ${JSON.stringify(value)}`);
+  }
+  return units;
+}
+
+// Resolve the units for a request: an explicit value is validated as usual, otherwise the signed-in user's
+// stored preference (profiles default to imperial) applies before the service-wide default
+export async function resolveUserUnits(userId: string | undefined, requested: unknown): Promise<Units> {
+  if (requested !== undefined && requested !== null && requested !== "") {
+    return normalizeUnits(requested);
+  }
+  if (!userId) {
+    return DEFAULT_UNITS;
+  }
+
+  try {
+    const userDoc = await db.collection("users").doc(userId).get();
+    return normalizeUnits(userDoc.data()?.preferences?.units);
+  } catch (error) {
+    // A profile with an unrecognized value (or a failed read) shouldn't fail the weather request
+    logger.warn(`Could not use stored units for user ${userId}, using ${DEFAULT_UNITS}:`, error);
+    return DEFAULT_UNITS;
+  }
+}
+
+// Convert a temperature between unit systems, rounded like the provider values we return
+export function convertTemperature(value: number, from: Units, to: Units): number {
+  if (from === to) {
+    return value;
+  }
+  return Math.round(to === "imperial" ? value * 9 / 5 + 32 : (value - 32) * 5 / 9);
+}
+
+// Convert a wind speed between m/s (metric) and mph (imperial), to one decimal place
+export function convertWindSpeed(value: number, from: Units, to: Units): number {
+  if (from === to) {
+    return value;
+  }
+  return Math.round((to === "imperial" ? value * 2.23694 : value / 2.23694) * 10) / 10;
+}
+
+// Convert a pressure between hPa (metric) and inHg (imperial), rounded like the values we return
+export function convertPressure(value: number, from: Units, to: Units): number {
+  if (from === to) {
+    return value;
+  }
+  return to === "imperial" ? Math.round(value * 0.02953 * 100) / 100 : Math.round(value / 0.02953);
+}
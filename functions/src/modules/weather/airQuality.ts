@@ -0,0 +1,110 @@
+// Air quality logic
+
+import * as logger from "firebase-functions/logger";
+import { HttpsError } from "firebase-functions/v2/https";
+import { AirQualityCategory, AirQualityData, AirQualityResponse, OpenWeatherAirPollutionResponse, WeatherRequest } from "../../types";
+import { getAirQualityCacheKey, getCacheGeneration, getCachedWeatherData, setCachedWeatherData } from "../shared/cache";
+import { geocodeQuery, validateCoordinates } from "../shared/location";
+import { weatherHttpClient } from "../shared/http";
+import { requestLogFields } from "../shared/requestContext";
+import { CACHE_TTL, weatherApiKey, WEATHER_CONFIG } from "../../config";
+import { assertWeatherProviderAvailable, normalizeWeatherError } from "./errors";
+
+// OpenWeatherMap's AQI scale, https://openweathermap.org/api/air-pollution
+const AQI_CATEGORIES: AirQualityCategory[] = ["Good", "Fair", "Moderate", "Poor", "Very Poor"];
+
+// Helper function to map the numeric AQI (1-5) to its category
+function getAqiCategory(aqi: number): AirQualityCategory {
+  return AQI_CATEGORIES[Math.min(Math.max(Math.round(aqi), 1), 5) - 1];
+}
+
+// Get current air quality
+export async function getAirQuality(request: WeatherRequest): Promise<AirQualityResponse> {
+  try {
+    const { query } = request;
+    let { latitude, longitude } = request;
+
+    // Get API key from Firebase Secret Manager or environment variable
+    let apiKey: string;
+    try {
+      apiKey = weatherApiKey.value().trim();
+    } catch {
+      // Fallback to environment variable for local development
+      apiKey = process.env.WEATHER_API_KEY || "";
+    }
+
+    // A city name or ZIP is only consulted when coordinates aren't supplied
+    if (latitude === undefined && longitude === undefined && query !== undefined) {
+      if (typeof query !== "string") {
+        throw new HttpsError("invalid-argument", "Location query must be a string");
+      }
+      if (!apiKey) {
+        throw new Error("Location search requires a weather API key");
+      }
+      ({ latitude, longitude } = await geocodeQuery(query, apiKey));
+    }
+
+    if (latitude === undefined || longitude === undefined) {
+      throw new HttpsError("invalid-argument", "Latitude and longitude, or a location query, are required");
+    }
+    validateCoordinates(latitude, longitude);
+
+    // Check cache first
+    const cacheKey = getAirQualityCacheKey(latitude, longitude);
+    const cacheGeneration = getCacheGeneration(cacheKey);
+    const cachedData = await getCachedWeatherData(cacheKey, CACHE_TTL.AIR_QUALITY);
+
+    if (cachedData) {
+      return { success: true, data: cachedData as AirQualityData, cached: true };
+    }
+
+    let data: OpenWeatherAirPollutionResponse;
+
+    if (!apiKey) {
+      // Return mock data for local development/testing
+      logger.info("No weather API key found, returning mock air quality data");
+      data = {
+        list: [{
+          dt: Math.floor(Date.now() / 1000),
+          main: { aqi: 2 },
+          components: { co: 230.3, no2: 12.1, o3: 68.7, so2: 1.9, pm2_5: 6.4, pm10: 9.8 },
+        }],
+      };
+    } else {
+      assertWeatherProviderAvailable();
+      const response = await weatherHttpClient.get<OpenWeatherAirPollutionResponse>(`${WEATHER_CONFIG.BASE_URL}/air_pollution`, {
+        params: {
+          lat: latitude,
+          lon: longitude,
+          appid: apiKey,
+        },
+      });
+      data = response.data;
+    }
+
+    const reading = data.list && data.list[0];
+    if (!reading) {
+      throw new Error("No air quality data for this location");
+    }
+
+    const airQuality: AirQualityData = {
+      aqi: reading.main.aqi,
+      category: getAqiCategory(reading.main.aqi),
+      pm2_5: reading.components.pm2_5,
+      pm10: reading.components.pm10,
+      o3: reading.components.o3,
+      no2: reading.components.no2,
+      co: reading.components.co,
+      so2: reading.components.so2,
+      timestamp: new Date(reading.dt * 1000).toISOString(),
+    };
+
+    // Cache the data
+    await setCachedWeatherData(cacheKey, airQuality, CACHE_TTL.AIR_QUALITY, cacheGeneration);
+
+    return { success: true, data: airQuality, cached: false };
+  } catch (error) {
+    logger.error("Error fetching air quality:", error, requestLogFields());
+    throw normalizeWeatherError(error, "Failed to fetch air quality");
+  }
+}
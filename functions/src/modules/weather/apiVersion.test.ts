@@ -0,0 +1,167 @@
+// Weather API version tests - 2.5 and One Call 3.0 fixtures served through a stubbed weather client.
+// Cache writes go to the Firestore emulator (npm test); every request asks for a refresh so reads are skipped
+
+import { after, afterEach, before, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { isDeepStrictEqual as isEqual } from "util";
+import { HttpsError } from "firebase-functions/v2/https";
+import { loadWeatherApiVersion, WEATHER_CONFIG, WeatherApiVersion } from "../../config";
+import { weatherHttpClient } from "../shared/http";
+import { getCurrentWeather } from "./current";
+import { getWeatherForecast } from "./forecast";
+
+const HOUR = 60 * 60;
+const now = Math.floor(Date.now() / 1000);
+const noonToday = Math.floor(Date.UTC(new Date().getUTCFullYear(), new Date().getUTCMonth(), new Date().getUTCDate(), 12) / 1000);
+const clear = [{ id: 800, description: "clear sky", icon: "01d" }];
+
+// OpenWeatherMap 2.5: /weather and the 5-day/3-hour /forecast
+const FIXTURE_2_5 = {
+  weather: {
+    main: { temp: 21.4, feels_like: 20.9, temp_min: 19, temp_max: 23, humidity: 55, pressure: 1016 },
+    weather: clear,
+    wind: { speed: 4.1, deg: 270 },
+    name: "Springfield",
+    sys: { country: "US" },
+  },
+  forecast: {
+    city: { name: "Springfield", country: "US", timezone: 0 },
+    list: Array.from({ length: 40 }, (_, i) => ({
+      dt: now + i * 3 * HOUR,
+      main: { temp: 15 + (i % 8), feels_like: 14 + (i % 8), temp_min: 15, temp_max: 22, humidity: 60, pressure: 1012 },
+      weather: clear,
+      wind: { speed: 3, deg: 90 },
+      pop: 0.1,
+    })),
+  },
+};
+
+// One Call 3.0: current (with UV), hourly, 8 daily entries and alerts in one response
+const FIXTURE_3_0 = {
+  timezone_offset: 0,
+  current: { dt: now, temp: 18.6, feels_like: 18.1, pressure: 1009, humidity: 71, uvi: 3.4, wind_speed: 5.2, wind_deg: 200, weather: clear },
+  hourly: Array.from({ length: 48 }, (_, i) => ({
+    dt: now + i * HOUR, temp: 18, feels_like: 17, pressure: 1009, humidity: 70, wind_speed: 5, wind_deg: 200, weather: clear, pop: 0.2,
+  })),
+  daily: Array.from({ length: 8 }, (_, i) => ({
+    dt: noonToday + i * 24 * HOUR,
+    temp: { min: 12 + i, max: 20 + i },
+    feels_like: { morn: 11 + i, day: 19 + i, eve: 17 + i, night: 12 + i },
+    pressure: 1010, humidity: 65, wind_speed: 4, wind_deg: 180, weather: clear, pop: 0.3,
+  })),
+  alerts: [{ sender_name: "NWS", event: "Wind Advisory", start: now, end: now + 6 * HOUR, description: "Gusts to 45 mph" }],
+};
+
+const originalGet = weatherHttpClient.get;
+const originalVersion = WEATHER_CONFIG.API_VERSION;
+let requested: string[] = [];
+
+before(() => {
+  process.env.weather_api_key = "test-key";
+  weatherHttpClient.get = (async (url: string) => {
+    requested.push(url);
+    const routes: { [url: string]: unknown } = {
+      [`${WEATHER_CONFIG.BASE_URL}/weather`]: FIXTURE_2_5.weather,
+      [`${WEATHER_CONFIG.BASE_URL}/forecast`]: FIXTURE_2_5.forecast,
+      [WEATHER_CONFIG.ONECALL_URL]: FIXTURE_3_0,
+    };
+    const data = url.includes("/geo/1.0/reverse") ? [{ name: "Springfield", state: "Illinois", country: "US" }] : routes[url];
+    if (data === undefined) {
+      throw new Error(`Unexpected weather request: ${url}`);
+    }
+    return { status: 200, data, headers: {} };
+  }) as unknown as typeof weatherHttpClient.get;
+});
+
+after(() => {
+  weatherHttpClient.get = originalGet;
+  delete process.env.weather_api_key;
+});
+
+afterEach(() => {
+  WEATHER_CONFIG.API_VERSION = originalVersion;
+  requested = [];
+});
+
+// Helper function to switch the API version for one test
+function useApiVersion(version: WeatherApiVersion): void {
+  WEATHER_CONFIG.API_VERSION = version;
+}
+
+test("2.5 serves current weather from /weather alone, flagged uv_unavailable, with no alerts", async () => {
+  useApiVersion("2.5");
+  const current = await getCurrentWeather({ latitude: 39.78, longitude: -89.65, units: "metric", refresh: true });
+  assert.equal(current.data.temperature, 21);
+  assert.equal(current.data.uvIndex, null);
+  assert.equal(current.degradedReason, "uv_unavailable");
+  assert.equal(current.data.alerts, undefined);
+  assert.deepEqual(requested.filter((url) => !url.includes("/geo/")), [`${WEATHER_CONFIG.BASE_URL}/weather`]);
+});
+
+test("2.5 forecasts cover five days and reject a sixth", async () => {
+  useApiVersion("2.5");
+  const forecast = await getWeatherForecast({ latitude: 39.78, longitude: -89.65, units: "metric", refresh: true });
+  assert.equal(forecast.data.days.length, 5);
+  await assert.rejects(getWeatherForecast({ latitude: 39.78, longitude: -89.65, units: "metric", refresh: true, days: 6 }),
+    (error: unknown) => error instanceof HttpsError && isEqual(error.details, { code: "forecast_days_exceed_plan", maxDays: 5 }));
+});
+
+test("3.0 serves current weather, UV and alerts from one One Call request", async () => {
+  useApiVersion("3.0");
+  const current = await getCurrentWeather({ latitude: 40.71, longitude: -74.01, units: "metric", refresh: true });
+  assert.equal(current.data.temperature, 19);
+  assert.equal(current.data.uvIndex, 3.4);
+  assert.deepEqual(current.data.alerts?.map((alert) => alert.event), ["Wind Advisory"]);
+  assert.deepEqual(requested.filter((url) => !url.includes("/geo/")), [WEATHER_CONFIG.ONECALL_URL]);
+  assert.equal(current.degraded, false);
+});
+
+test("3.0 forecasts cover eight days", async () => {
+  useApiVersion("3.0");
+  const forecast = await getWeatherForecast({ latitude: 40.71, longitude: -74.01, units: "metric", refresh: true, days: 8 });
+  assert.equal(forecast.data.days.length, 8);
+  assert.equal(forecast.data.days[0].highTemp, 20);
+});
+
+test("3.0 feels-like highs and lows span the morning, day, evening and night readings", async () => {
+  useApiVersion("3.0");
+  const forecast = await getWeatherForecast({ latitude: 40.71, longitude: -74.01, units: "metric", refresh: true, days: 3 });
+  assert.deepEqual(forecast.data.days.map((day) => [day.feelsLikeHigh, day.feelsLikeLow]), [[19, 11], [20, 12], [21, 13]]);
+});
+
+test("a forecast cached in one API version isn't served in the other", async () => {
+  const location = { latitude: 35.15, longitude: -90.05, units: "metric" as const };
+  useApiVersion("2.5");
+  assert.equal((await getWeatherForecast(location)).data.days.length, 5);
+  assert.equal((await getWeatherForecast(location)).cached, true);
+
+  useApiVersion("3.0");
+  const oneCall = await getWeatherForecast({ ...location, days: 8 });
+  assert.equal(oneCall.cached, false);
+  assert.equal(oneCall.data.days.length, 8);
+});
+
+test("WEATHER_API_PLAN must agree with WEATHER_API_VERSION", () => {
+  const saved = { version: process.env.WEATHER_API_VERSION, plan: process.env.WEATHER_API_PLAN };
+  const setEnv = (version?: string, plan?: string) => {
+    if (version === undefined) delete process.env.WEATHER_API_VERSION; else process.env.WEATHER_API_VERSION = version;
+    if (plan === undefined) delete process.env.WEATHER_API_PLAN; else process.env.WEATHER_API_PLAN = plan;
+  };
+
+  try {
+    setEnv(undefined, undefined);
+    assert.equal(loadWeatherApiVersion(), "2.5");
+    setEnv(undefined, "onecall");
+    assert.equal(loadWeatherApiVersion(), "3.0");
+    setEnv("3.0", "onecall");
+    assert.equal(loadWeatherApiVersion(), "3.0");
+    setEnv("2.5", "onecall");
+    assert.throws(() => loadWeatherApiVersion(), /needs WEATHER_API_VERSION=3.0/);
+    setEnv("3.0", "free");
+    assert.throws(() => loadWeatherApiVersion(), /needs WEATHER_API_VERSION=2.5/);
+    setEnv("4.0", undefined);
+    assert.throws(() => loadWeatherApiVersion(), /must be 2.5 or 3.0/);
+  } finally {
+    setEnv(saved.version, saved.plan);
+  }
+});
@@ -0,0 +1,43 @@
+// Current weather for several locations in one call
+
+import { HttpsError } from "firebase-functions/v2/https";
+import { WeatherBatchItem, WeatherBatchRequest, WeatherBatchResponse, WeatherRequest } from "../../types";
+import { WEATHER_CONFIG } from "../../config";
+import { getCurrentWeather } from "./current";
+
+// Get current weather for each location with bounded concurrency, reporting failures per item.
+// prepare runs per item before the lookup (e.g. resolving a saved locationId)
+export async function getCurrentWeatherBatch(
+  request: WeatherBatchRequest,
+  prepare: (location: WeatherRequest) => Promise<WeatherRequest> = async (location) => location
+): Promise<WeatherBatchResponse> {
+  const locations = request?.locations;
+  if (!Array.isArray(locations) || locations.length === 0) {
+    throw new HttpsError("invalid-argument", "locations must be a non-empty array");
+  }
+  if (locations.length > WEATHER_CONFIG.MAX_BATCH_SIZE) {
+    throw new HttpsError("invalid-argument", `At most ${WEATHER_CONFIG.MAX_BATCH_SIZE} locations per batch, got ${locations.length}`);
+  }
+
+  const results: WeatherBatchItem[] = new Array(locations.length);
+  let next = 0;
+
+  // Each worker takes the next unclaimed index until none remain; the cache is shared as usual
+  const worker = async () => {
+    while (next < locations.length) {
+      const index = next++;
+      try {
+        const prepared = await prepare({ ...locations[index], units: request.units });
+        const { success, data, cached, degraded, degradedReason } = await getCurrentWeather(prepared);
+        results[index] = { success, data, cached, degraded, degradedReason };
+      } catch (error) {
+        results[index] = { success: false, error: error instanceof Error ? error.message : "Unknown error" };
+      }
+    }
+  };
+
+  const workerCount = Math.min(Math.max(1, WEATHER_CONFIG.BATCH_CONCURRENCY), locations.length);
+  await Promise.all(Array.from({ length: workerCount }, worker));
+
+  return { success: true, results };
+}
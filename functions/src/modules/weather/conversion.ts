@@ -0,0 +1,46 @@
+// Unit conversion for cached weather - a reading cached in one unit system can answer a request in the other
+// without another provider call. Values are converted from the rounded cached figures, so they can be off by
+// one from what the provider would have returned
+
+import { WeatherData, ForecastData } from "../../types";
+import { convertPressure, convertTemperature, convertWindSpeed, Units } from "../shared/units";
+
+// Get the unit system a cached reading in the other units would be stored under
+export function otherUnits(units: Units): Units {
+  return units === "imperial" ? "metric" : "imperial";
+}
+
+// Convert current weather between unit systems
+export function convertWeatherData(data: WeatherData, from: Units, to: Units): WeatherData {
+  if (from === to) {
+    return data;
+  }
+  return {
+    ...data,
+    temperature: convertTemperature(data.temperature, from, to),
+    windSpeed: convertWindSpeed(data.windSpeed, from, to),
+    pressure: convertPressure(data.pressure, from, to),
+  };
+}
+
+// Convert a forecast between unit systems (copies - the input may be the in-memory cached object)
+export function convertForecastData(data: ForecastData, from: Units, to: Units): ForecastData {
+  if (from === to) {
+    return data;
+  }
+  return {
+    ...data,
+    days: data.days.map((day) => ({
+      ...day,
+      highTemp: convertTemperature(day.highTemp, from, to),
+      lowTemp: convertTemperature(day.lowTemp, from, to),
+      feelsLikeHigh: convertTemperature(day.feelsLikeHigh, from, to),
+      feelsLikeLow: convertTemperature(day.feelsLikeLow, from, to),
+      windSpeed: convertWindSpeed(day.windSpeed, from, to),
+      pressure: convertPressure(day.pressure, from, to),
+    })),
+    ...(data.hourly && {
+      hourly: data.hourly.map((hour) => ({ ...hour, temperature: convertTemperature(hour.temperature, from, to) })),
+    }),
+  };
+}
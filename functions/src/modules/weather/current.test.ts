@@ -0,0 +1,94 @@
+// Current weather input tests - bad location input reaches the caller as a client error, not INTERNAL.
+// The weather client is stubbed; cache reads and writes go to the Firestore emulator (npm test)
+
+import { after, afterEach, before, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { HttpsError } from "firebase-functions/v2/https";
+import { WEATHER_CONFIG } from "../../config";
+import { weatherHttpClient } from "../shared/http";
+import { getCurrentWeather, primaryCondition } from "./current";
+
+const originalGet = weatherHttpClient.get;
+const originalVersion = WEATHER_CONFIG.API_VERSION;
+
+before(() => {
+  process.env.weather_api_key = "test-key";
+});
+
+afterEach(() => {
+  weatherHttpClient.get = originalGet;
+  WEATHER_CONFIG.API_VERSION = originalVersion;
+});
+
+after(() => {
+  delete process.env.weather_api_key;
+});
+
+// Helper function to match an HttpsError with the given code
+function withCode(code: string) {
+  return (error: unknown) => error instanceof HttpsError && error.code === code;
+}
+
+test("a non-string query and a request without any location are invalid-argument", async () => {
+  await assert.rejects(getCurrentWeather({ query: 94040 as unknown as string }), withCode("invalid-argument"));
+  await assert.rejects(getCurrentWeather({}), withCode("invalid-argument"));
+  await assert.rejects(getCurrentWeather({ query: "  " }), withCode("invalid-argument"));
+});
+
+test("a query that geocodes to nothing is not-found", async () => {
+  weatherHttpClient.get = (async () => ({ status: 200, data: [], headers: {} })) as unknown as typeof weatherHttpClient.get;
+  await assert.rejects(getCurrentWeather({ query: "Atlantis" }), withCode("not-found"));
+});
+
+test("out-of-range coordinates are invalid-argument", async () => {
+  await assert.rejects(getCurrentWeather({ latitude: 95, longitude: 10 }), withCode("invalid-argument"));
+});
+
+test("the first listed condition is primary, and a missing or empty list reads as unknown", () => {
+  const rain = { id: 500, description: "light rain", icon: "10d" };
+  assert.deepEqual(primaryCondition([rain, { id: 701, description: "mist", icon: "50d" }]), rain);
+  assert.deepEqual(primaryCondition([]), { description: "unknown", icon: "" });
+  assert.deepEqual(primaryCondition(undefined), { description: "unknown", icon: "" });
+});
+
+// Helper function to serve a 2.5 /weather reading (and reverse geocoding) with the given conditions
+function serveReading(weather: { id: number; description: string; icon: string }[]): void {
+  WEATHER_CONFIG.API_VERSION = "2.5";
+  weatherHttpClient.get = (async (url: string) => ({
+    status: 200,
+    headers: {},
+    data: url.includes("/geo/1.0/reverse") ? [{ name: "Testville", country: "US" }] : {
+      main: { temp: 12.4, feels_like: 11, temp_min: 12, temp_max: 13, humidity: 70, pressure: 1008 },
+      weather,
+      wind: { speed: 2, deg: 180 },
+      name: "Testville",
+      sys: { country: "US" },
+    },
+  })) as unknown as typeof weatherHttpClient.get;
+}
+
+test("a reading with an empty weather array is served as an unknown condition", async () => {
+  serveReading([]);
+
+  const { data } = await getCurrentWeather({ latitude: 44.1, longitude: -93.2, units: "metric", refresh: true });
+  assert.equal(data.temperature, 12);
+  assert.equal(data.condition, "unknown");
+  assert.equal(data.conditionCode, null);
+  assert.equal(data.icon, "");
+});
+
+test("dual adds the other unit system's temperature and wind to the response only", async () => {
+  serveReading([{ id: 800, description: "clear sky", icon: "01d" }]);
+
+  const dual = await getCurrentWeather({ latitude: 44.2, longitude: -93.3, units: "metric", dual: true, refresh: true });
+  assert.equal(dual.data.temperature, 12);
+  assert.deepEqual(dual.data.alternateUnits, { units: "imperial", temperature: 54, windSpeed: 4.5 });
+
+  // Served from the entry the dual request cached, which never carries the alternate figures
+  const single = await getCurrentWeather({ latitude: 44.2, longitude: -93.3, units: "metric" });
+  assert.equal(single.cached, true);
+  assert.equal(single.data.alternateUnits, undefined);
+
+  const imperial = await getCurrentWeather({ latitude: 44.2, longitude: -93.3, units: "imperial", dual: true });
+  assert.equal(imperial.data.alternateUnits?.units, "metric");
+});
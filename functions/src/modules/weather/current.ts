@@ -1,11 +1,18 @@
 // Current weather logic
 
-import axios from "axios";
 import * as logger from "firebase-functions/logger";
-import { WeatherRequest, WeatherData, WeatherResponse, OpenWeatherCurrentResponse } from "../../types";
-import { getCacheKey, getCachedWeatherData, setCachedWeatherData } from "../shared/cache";
-import { getDetailedLocation } from "../shared/location";
-import { CACHE_TTL, weatherApiKey } from "../../config";
+import { HttpsError } from "firebase-functions/v2/https";
+import { WeatherRequest, WeatherData, WeatherResponse, OpenWeatherCurrentResponse, OpenWeatherWeather, WeatherAlert } from "../../types";
+import { getCacheGeneration, getCacheKey, getCachedWeatherData, setCachedWeatherData } from "../shared/cache";
+import { geocodeQuery, getDetailedLocation, validateCoordinates } from "../shared/location";
+import { weatherHttpClient } from "../shared/http";
+import { convertTemperature, convertWindSpeed, normalizeUnits, Units } from "../shared/units";
+import { memoizeForRequest, requestLogFields } from "../shared/requestContext";
+import { CACHE_TTL, weatherApiKey, WEATHER_CONFIG } from "../../config";
+import { degradation } from "./degraded";
+import { convertWeatherData, otherUnits } from "./conversion";
+import { fetchOneCall, isOneCallMode, toCurrentResponse, toWeatherAlerts } from "./onecall";
+import { assertWeatherProviderAvailable, normalizeWeatherError } from "./errors";
 
 // Helper function to convert wind degrees to direction
 function getWindDirection(degrees: number): string {
@@ -14,40 +21,99 @@ function getWindDirection(degrees: number): string {
   return directions[index];
 }
 
-// Get current weather data
+// OpenWeatherMap can send an empty (or missing) weather array on partial responses - use a placeholder instead of crashing
+const UNKNOWN_CONDITION: OpenWeatherWeather = { description: "unknown", icon: "" };
+
+// Get the primary weather condition from an OpenWeatherMap reading
+export function primaryCondition(weather: OpenWeatherWeather[] | undefined): OpenWeatherWeather {
+  return (weather && weather[0]) || UNKNOWN_CONDITION;
+}
+
+// Helper function to add the other unit system's temperature and wind (applied per response, never cached)
+function withAlternateUnits(data: WeatherData, units: Units): WeatherData {
+  const alternate: Units = units === "imperial" ? "metric" : "imperial";
+  return {
+    ...data,
+    alternateUnits: {
+      units: alternate,
+      temperature: convertTemperature(data.temperature, units, alternate),
+      windSpeed: convertWindSpeed(data.windSpeed, units, alternate),
+    },
+  };
+}
+
+// Get current weather data - memoized per request, so aggregate endpoints that ask twice only fetch once
 export async function getCurrentWeather(request: WeatherRequest): Promise<WeatherResponse> {
+  const { latitude, longitude, query, units, dual, refresh } = request;
+  const memoKey = `current:${JSON.stringify([latitude, longitude, query, normalizeUnits(units), !!dual, !!refresh])}`;
+  return memoizeForRequest(memoKey, () => fetchCurrentWeather(request));
+}
+
+// Fetch current weather from cache or OpenWeatherMap
+async function fetchCurrentWeather(request: WeatherRequest): Promise<WeatherResponse> {
   try {
-    const { latitude, longitude, units = "metric" } = request;
+    const { query } = request;
+    const units = normalizeUnits(request.units);
+    let { latitude, longitude } = request;
+
+    // Get API key from Firebase Secret Manager or environment variable
+    let apiKey: string;
+    try {
+      apiKey = weatherApiKey.value().trim();
+      logger.info("Using secret manager API key");
+    } catch {
+      // Fallback to environment variable for local development
+      logger.info("Secret not available, trying environment variable");
+      apiKey = process.env.WEATHER_API_KEY || "";
+    }
 
-    if (!latitude || !longitude) {
-      throw new Error("Latitude and longitude are required");
+    // A city name or ZIP is only consulted when coordinates aren't supplied
+    if (latitude === undefined && longitude === undefined && query !== undefined) {
+      if (typeof query !== "string") {
+        throw new HttpsError("invalid-argument", "Location query must be a string");
+      }
+      if (!apiKey) {
+        throw new Error("Location search requires a weather API key");
+      }
+      ({ latitude, longitude } = await geocodeQuery(query, apiKey));
     }
 
-    // Check cache first
+    if (latitude === undefined || longitude === undefined) {
+      throw new HttpsError("invalid-argument", "Latitude and longitude, or a location query, are required");
+    }
+    validateCoordinates(latitude, longitude);
+
+    // Check cache first, unless the caller asked for a fresh reading
     const cacheKey = getCacheKey("current", latitude, longitude, units);
-    const cachedData = await getCachedWeatherData(cacheKey, CACHE_TTL.CURRENT_WEATHER);
+    const cacheGeneration = getCacheGeneration(cacheKey);
+    const cachedData = request.refresh ? null : await getCachedWeatherData(cacheKey, CACHE_TTL.CURRENT_WEATHER);
     
     if (cachedData) {
       logger.info(`Returning cached weather data for ${(cachedData as WeatherData).location}`);
       return {
         success: true,
-        data: cachedData as WeatherData,
+        data: request.dual ? withAlternateUnits(cachedData as WeatherData, units) : cachedData as WeatherData,
         cached: true,
+        ...degradation((cachedData as WeatherData).uvIndex === null ? ["uv_unavailable"] : []),
       };
     }
 
-    // Get API key from Firebase Secret Manager or environment variable
-    let apiKey: string;
-    try {
-      apiKey = weatherApiKey.value().trim();
-      logger.info("Using secret manager API key");
-    } catch {
-      // Fallback to environment variable for local development
-      logger.info("Secret not available, trying environment variable");
-      apiKey = process.env.WEATHER_API_KEY || "";
+    // The same reading cached in the other unit system is converted rather than re-fetched
+    const otherCached = request.refresh ? null : await getCachedWeatherData(getCacheKey("current", latitude, longitude, otherUnits(units)), CACHE_TTL.CURRENT_WEATHER);
+    if (otherCached) {
+      const converted = convertWeatherData(otherCached as WeatherData, otherUnits(units), units);
+      logger.info(`Returning cached weather data for ${converted.location}, converted to ${units}`);
+      return {
+        success: true,
+        data: request.dual ? withAlternateUnits(converted, units) : converted,
+        cached: true,
+        ...degradation(converted.uvIndex === null ? ["uv_unavailable"] : []),
+      };
     }
-    
+
     let data: OpenWeatherCurrentResponse;
+    let uvIndex: number | null;
+    let alerts: WeatherAlert[] | undefined;
     
     if (!apiKey) {
       // Return mock data for local development/testing
@@ -61,6 +127,7 @@ export async function getCurrentWeather(request: WeatherRequest): Promise<Weathe
           pressure: 1013
         },
         weather: [{
+          id: 800,
           description: "sunny",
           icon: "01d"
         }],
@@ -73,19 +140,30 @@ export async function getCurrentWeather(request: WeatherRequest): Promise<Weathe
           country: "US"
         }
       };
+      uvIndex = 5;
+    } else if (isOneCallMode()) {
+      // One Call 3.0 carries UV and alerts in the same response
+      assertWeatherProviderAvailable();
+      logger.info("Calling OpenWeatherMap One Call 3.0 API");
+      const oneCall = await fetchOneCall(latitude, longitude, units, apiKey.trim());
+      ({ data, uvIndex } = toCurrentResponse(oneCall));
+      alerts = toWeatherAlerts(oneCall);
     } else {
       // Use OpenWeatherMap API
+      assertWeatherProviderAvailable();
       logger.info("Calling OpenWeatherMap API with real data");
-      const url = "https://api.openweathermap.org/data/2.5/weather";
+      const url = `${WEATHER_CONFIG.BASE_URL}/weather`;
       const params = {
         lat: latitude,
         lon: longitude,
         appid: apiKey.trim(),
-        units: units || "metric",
+        units,
       };
 
-      const response = await axios.get(url, {params});
+      // 2.5 has no UV source (its One Call endpoint is retired), so the reading is flagged uv_unavailable
+      const response = await weatherHttpClient.get(url, {params});
       data = response.data;
+      uvIndex = null;
     }
 
     // Convert pressure based on units
@@ -100,29 +178,35 @@ export async function getCurrentWeather(request: WeatherRequest): Promise<Weathe
       `${data.name}, ${data.sys.country}`;
 
     // Transform weather data to our format
+    const condition = primaryCondition(data.weather);
     const weatherData: WeatherData = {
       temperature: Math.round(data.main.temp),
-      condition: data.weather[0].description,
+      condition: condition.description,
+      conditionCode: condition.id ?? null,
+      icon: condition.icon,
       humidity: data.main.humidity,
       windSpeed: data.wind.speed,
       windDirection: data.wind.deg ? getWindDirection(data.wind.deg) : "N/A",
       pressure: convertedPressure,
+      uvIndex,
       location: detailedLocation,
       timestamp: new Date().toISOString(),
+      ...(alerts && { alerts }),
     };
 
     logger.info(`Retrieved weather data for ${weatherData.location}`);
 
     // Cache the data
-    await setCachedWeatherData(cacheKey, weatherData, CACHE_TTL.CURRENT_WEATHER);
+    await setCachedWeatherData(cacheKey, weatherData, CACHE_TTL.CURRENT_WEATHER, cacheGeneration);
 
     return {
       success: true,
-      data: weatherData,
+      data: request.dual ? withAlternateUnits(weatherData, units) : weatherData,
       cached: false,
+      ...degradation(uvIndex === null ? ["uv_unavailable"] : []),
     };
   } catch (error) {
-    logger.error("Error fetching weather data:", error);
-    throw new Error(`Failed to fetch weather data: ${error instanceof Error ? error.message : "Unknown error"}`);
+    logger.error("Error fetching weather data:", error, requestLogFields());
+    throw normalizeWeatherError(error, "Failed to fetch weather data");
   }
 }
@@ -0,0 +1,17 @@
+// Degraded-data signalling shared by the weather responses
+
+import { DegradedReason } from "../../types";
+
+// Build the degraded/degradedReason pair for a response from the reasons that applied
+export function degradation(reasons: DegradedReason[]): {degraded: boolean; degradedReason: string | null} {
+  const unique = reasons.filter((reason, index) => reasons.indexOf(reason) === index);
+  return {
+    degraded: unique.length > 0,
+    degradedReason: unique.length > 0 ? unique.join(", ") : null,
+  };
+}
+
+// Helper function to recover the reasons from a response built by degradation()
+export function degradedReasons(response: {degradedReason: string | null}): DegradedReason[] {
+  return response.degradedReason ? response.degradedReason.split(", ") as DegradedReason[] : [];
+}
@@ -0,0 +1,81 @@
+// Weather error normalization and rate-limit backoff tests - provider failures built as axios errors, no network.
+// The backoff window is module state, so the 429 tests run last and mock Date to move through it
+
+import { test } from "node:test";
+import * as assert from "node:assert/strict";
+import { isDeepStrictEqual as isEqual } from "util";
+import { AxiosError, AxiosResponse } from "axios";
+import { HttpsError } from "firebase-functions/v2/https";
+import { assertWeatherProviderAvailable, normalizeWeatherError } from "./errors";
+
+// Helper function to build the error axios raises for a provider response with the given status
+function providerError(status: number, data: unknown = {}, headers: { [name: string]: string } = {}): AxiosError {
+  const response = { status, statusText: "", data, headers, config: {} } as AxiosResponse;
+  return new AxiosError(`Request failed with status code ${status}`, "ERR_BAD_REQUEST", undefined, undefined, response);
+}
+
+test("a rejected API key maps to a generic auth error, distinct from an outage", () => {
+  const error = normalizeWeatherError(providerError(401, { cod: 401, message: "Invalid API key abc123" }), "Failed to fetch weather data");
+
+  assert.ok(error instanceof HttpsError);
+  assert.equal(error.code, "failed-precondition");
+  assert.deepEqual(error.details, { code: "weather_provider_auth" });
+  assert.ok(!error.message.includes("abc123"));
+  assert.notEqual(error.code, "unavailable");
+});
+
+test("HttpsErrors pass through untouched", () => {
+  const original = new HttpsError("not-found", "No location found");
+  assert.equal(normalizeWeatherError(original, "Failed to fetch weather data"), original);
+});
+
+test("other failures keep their message behind the context", () => {
+  const error = normalizeWeatherError(new Error("socket hang up"), "Failed to fetch weather forecast");
+  assert.ok(!(error instanceof HttpsError));
+  assert.equal(error.message, "Failed to fetch weather forecast: socket hang up");
+});
+
+// Helper function to match the error returned while backing off
+function rateLimited(retryAfterSeconds: number) {
+  return (error: unknown) => error instanceof HttpsError && error.code === "resource-exhausted" &&
+    isEqual(error.details, { code: "weather_provider_rate_limited", retryAfterSeconds });
+}
+
+test("a 429 short-circuits provider calls until its Retry-After, then calls resume", (t) => {
+  t.mock.timers.enable({ apis: ["Date"], now: 1_000_000 });
+  assertWeatherProviderAvailable();
+
+  const error = normalizeWeatherError(providerError(429, {}, { "retry-after": "30" }), "Failed to fetch weather data");
+  assert.ok(rateLimited(30)(error));
+
+  assert.throws(() => assertWeatherProviderAvailable(), rateLimited(30));
+  t.mock.timers.tick(20_000);
+  assert.throws(() => assertWeatherProviderAvailable(), rateLimited(10));
+  t.mock.timers.tick(10_000);
+  assertWeatherProviderAvailable();
+});
+
+test("X-RateLimit-Reset is honored, and a 429 without reset information backs off for a minute", (t) => {
+  t.mock.timers.enable({ apis: ["Date"], now: 2_000_000 });
+
+  normalizeWeatherError(providerError(429, {}, { "x-ratelimit-reset": String(2_000_000 / 1000 + 5) }), "Failed to fetch weather data");
+  assert.throws(() => assertWeatherProviderAvailable(), rateLimited(5));
+  t.mock.timers.tick(5_000);
+  assertWeatherProviderAvailable();
+
+  normalizeWeatherError(providerError(429), "Failed to fetch weather data");
+  assert.throws(() => assertWeatherProviderAvailable(), rateLimited(60));
+  t.mock.timers.tick(60_000);
+  assertWeatherProviderAvailable();
+});
+
+test("a shorter reset never cuts an existing backoff short", (t) => {
+  t.mock.timers.enable({ apis: ["Date"], now: 3_000_000 });
+
+  normalizeWeatherError(providerError(429, {}, { "retry-after": "40" }), "Failed to fetch weather data");
+  normalizeWeatherError(providerError(429, {}, { "retry-after": "5" }), "Failed to fetch weather data");
+  t.mock.timers.tick(10_000);
+  assert.throws(() => assertWeatherProviderAvailable(), rateLimited(30));
+  t.mock.timers.tick(30_000);
+  assertWeatherProviderAvailable();
+});
@@ -0,0 +1,70 @@
+// Error normalization for weather provider failures
+
+import axios from "axios";
+import * as logger from "firebase-functions/logger";
+import { HttpsError } from "firebase-functions/v2/https";
+import { requestLogFields } from "../shared/requestContext";
+
+// Fallback backoff when a 429 carries no usable reset information
+const DEFAULT_RATE_LIMIT_BACKOFF_MS = 60 * 1000;
+
+// When this instance may call the provider again after a 429 (epoch ms, 0 when not backing off)
+let providerBackoffUntil = 0;
+
+// Helper function to read the reset time from a 429 - Retry-After (seconds or HTTP date) or X-RateLimit-Reset (epoch seconds)
+function parseRateLimitReset(headers: { [name: string]: unknown }): number {
+  const retryAfter = headers["retry-after"];
+  if (typeof retryAfter === "string") {
+    const seconds = Number(retryAfter);
+    const resetAt = Number.isFinite(seconds) ? Date.now() + seconds * 1000 : Date.parse(retryAfter);
+    if (Number.isFinite(resetAt)) {
+      return resetAt;
+    }
+  }
+
+  const reset = Number(headers["x-ratelimit-reset"]);
+  if (Number.isFinite(reset) && reset > 0) {
+    return reset * 1000;
+  }
+
+  return Date.now() + DEFAULT_RATE_LIMIT_BACKOFF_MS;
+}
+
+// Helper function to build the error returned while backing off
+function rateLimitedError(): HttpsError {
+  const retryAfterSeconds = Math.max(1, Math.ceil((providerBackoffUntil - Date.now()) / 1000));
+  return new HttpsError("resource-exhausted", "Weather provider rate limit reached, try again later", {
+    code: "weather_provider_rate_limited",
+    retryAfterSeconds,
+  });
+}
+
+// Fail fast instead of calling the provider before its rate-limit reset time
+export function assertWeatherProviderAvailable(): void {
+  if (Date.now() < providerBackoffUntil) {
+    throw rateLimitedError();
+  }
+}
+
+// Map a failure to what the caller should see - a rejected API key becomes a stable, generic error that's
+// distinct from provider downtime (failed-precondition, not unavailable) so a bad key isn't mistaken for an outage
+export function normalizeWeatherError(error: unknown, context: string): Error {
+  if (error instanceof HttpsError) {
+    return error;
+  }
+
+  if (axios.isAxiosError(error) && error.response?.status === 401) {
+    // A key problem affects every request, so log it loudly but never echo the provider's body
+    logger.error(`🚨 OpenWeatherMap rejected the API key (401) - check the WEATHER_API_KEY secret. ${context}`, requestLogFields());
+    return new HttpsError("failed-precondition", "Weather provider rejected the service credentials", { code: "weather_provider_auth" });
+  }
+
+  if (axios.isAxiosError(error) && error.response?.status === 429) {
+    // Honor the provider's reset time rather than hammering it with every request until then
+    providerBackoffUntil = Math.max(providerBackoffUntil, parseRateLimitReset(error.response.headers));
+    logger.warn(`OpenWeatherMap rate limit hit, backing off until ${new Date(providerBackoffUntil).toISOString()}. ${context}`, requestLogFields());
+    return rateLimitedError();
+  }
+
+  return new Error(`${context}: ${error instanceof Error ? error.message : "Unknown error"}`);
+}
@@ -0,0 +1,243 @@
+// Forecast tests - 2.5 /forecast fixtures served through a stubbed weather client. Every request asks for a refresh
+// so cache reads are skipped; cache writes go to the Firestore emulator (npm test)
+
+import { after, afterEach, before, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { HttpsError } from "firebase-functions/v2/https";
+import { WEATHER_CONFIG } from "../../config";
+import { ForecastData, ForecastDay, OpenWeatherForecastItem } from "../../types";
+import { weatherHttpClient } from "../shared/http";
+import { applyTemperatureTrend, getWeatherForecast } from "./forecast";
+
+const HOUR = 60 * 60;
+const today = new Date();
+const tomorrowStart = Date.UTC(today.getUTCFullYear(), today.getUTCMonth(), today.getUTCDate() + 1) / 1000;
+
+// Helper function to build one 3-hourly reading, dayOffset days after tomorrow at the given UTC hour
+function reading(dayOffset: number, hour: number, overrides: Partial<OpenWeatherForecastItem> = {}): OpenWeatherForecastItem {
+  return {
+    dt: tomorrowStart + dayOffset * 24 * HOUR + hour * HOUR,
+    main: { temp: 15, feels_like: 14, temp_min: 15, temp_max: 15, humidity: 60, pressure: 1012 },
+    weather: [{ id: 800, description: "clear sky", icon: "01d" }],
+    wind: { speed: 3, deg: 90 },
+    pop: 0,
+    ...overrides,
+  };
+}
+
+// Helper function to get a fixture day's YYYY-MM-DD date
+function fixtureDate(dayOffset: number): string {
+  return new Date((tomorrowStart + dayOffset * 24 * HOUR) * 1000).toISOString().slice(0, 10);
+}
+
+// Helper function to match an HttpsError with the given code
+function withCode(code: string, text = "") {
+  return (error: unknown) => error instanceof HttpsError && error.code === code && error.message.includes(text);
+}
+
+const originalGet = weatherHttpClient.get;
+const originalVersion = WEATHER_CONFIG.API_VERSION;
+let forecastList: OpenWeatherForecastItem[] = [];
+let cityTimezone = 0; // Seconds east of UTC, as /forecast reports it
+let currentTemp: number | null = 15; // null makes the current-weather lookup fail
+
+before(() => {
+  process.env.weather_api_key = "test-key";
+  WEATHER_CONFIG.API_VERSION = "2.5";
+  weatherHttpClient.get = (async (url: string) => {
+    if (url === `${WEATHER_CONFIG.BASE_URL}/forecast`) {
+      return { status: 200, headers: {}, data: { city: { name: "Testville", country: "US", timezone: cityTimezone }, list: forecastList } };
+    }
+    if (url === `${WEATHER_CONFIG.BASE_URL}/weather`) {
+      if (currentTemp === null) {
+        throw new Error("current weather down");
+      }
+      return {
+        status: 200,
+        headers: {},
+        data: {
+          main: { temp: currentTemp, feels_like: currentTemp, temp_min: currentTemp, temp_max: currentTemp, humidity: 50, pressure: 1012 },
+          weather: [{ id: 800, description: "clear sky", icon: "01d" }],
+          wind: { speed: 2, deg: 90 },
+          name: "Testville",
+          sys: { country: "US" },
+        },
+      };
+    }
+    if (url.includes("/geo/1.0/reverse")) {
+      return { status: 200, headers: {}, data: [{ name: "Testville", country: "US" }] };
+    }
+    throw new Error(`Unexpected weather request: ${url}`);
+  }) as unknown as typeof weatherHttpClient.get;
+});
+
+afterEach(() => {
+  forecastList = [];
+  cityTimezone = 0;
+  currentTemp = 15;
+});
+
+after(() => {
+  weatherHttpClient.get = originalGet;
+  WEATHER_CONFIG.API_VERSION = originalVersion;
+  delete process.env.weather_api_key;
+});
+
+// Helper function to build a forecast day with the given high and low for the trend helper
+function day(highTemp: number, lowTemp: number): ForecastDay {
+  return {
+    date: "2026-01-01", dayName: "Thursday", highTemp, lowTemp, feelsLikeHigh: highTemp, feelsLikeLow: lowTemp,
+    condition: "clear sky", icon: "01d", humidity: 50, windSpeed: 3, windDirection: "E", pressure: 1012, precipitation: 0,
+  };
+}
+
+test("the trend compares each day's mean temperature with now", () => {
+  const forecast: ForecastData = { location: "Testville", days: [day(24, 16), day(26, 18)] };
+
+  const warming = applyTemperatureTrend(forecast, 15);
+  assert.deepEqual(warming.days.map((d) => d.tempDelta), [5, 7]);
+  assert.equal(warming.trend, "warming");
+
+  assert.equal(applyTemperatureTrend(forecast, 27).trend, "cooling");
+  assert.equal(applyTemperatureTrend(forecast, 21).trend, "steady");
+  assert.equal(forecast.days[0].tempDelta, undefined, "the cached forecast object must not be mutated");
+});
+
+test("the trend threshold is two degrees either way, and an empty forecast is steady", () => {
+  const forecast: ForecastData = { location: "Testville", days: [day(22, 18)] };
+  assert.equal(applyTemperatureTrend(forecast, 18).trend, "warming");
+  assert.equal(applyTemperatureTrend(forecast, 19).trend, "steady");
+  assert.equal(applyTemperatureTrend(forecast, 22).trend, "cooling");
+  assert.equal(applyTemperatureTrend({ location: "Testville", days: [] }, 10).trend, "steady");
+});
+
+test("includeTrend annotates the forecast against current conditions", async () => {
+  forecastList = [reading(0, 0, { main: { temp: 20, feels_like: 20, temp_min: 20, temp_max: 20, humidity: 60, pressure: 1012 } }),
+    reading(0, 12, { main: { temp: 26, feels_like: 26, temp_min: 26, temp_max: 26, humidity: 60, pressure: 1012 } })];
+  currentTemp = 15;
+
+  const forecast = await getWeatherForecast({ latitude: 41.1, longitude: -87.1, units: "metric", refresh: true, includeTrend: true });
+  assert.equal(forecast.data.trend, "warming");
+  assert.deepEqual(forecast.data.days.map((d) => d.tempDelta), [8]);
+  assert.equal(forecast.degraded, false);
+
+  const plain = await getWeatherForecast({ latitude: 41.1, longitude: -87.1, units: "metric", refresh: true });
+  assert.equal(plain.data.trend, undefined);
+});
+
+test("the forecast is still served, flagged trend_unavailable, when current weather fails", async () => {
+  forecastList = [reading(0, 12)];
+  currentTemp = null;
+
+  const forecast = await getWeatherForecast({ latitude: 41.2, longitude: -87.2, units: "metric", refresh: true, includeTrend: true });
+  assert.equal(forecast.data.days.length, 1);
+  assert.equal(forecast.data.trend, undefined);
+  assert.equal(forecast.degradedReason, "trend_unavailable");
+});
+
+test("date narrows the forecast to that one day", async () => {
+  forecastList = [0, 1, 2].map((offset) => reading(offset, 12));
+
+  const forecast = await getWeatherForecast({ latitude: 41.3, longitude: -87.3, units: "metric", refresh: true, date: fixtureDate(1) });
+  assert.deepEqual(forecast.data.days.map((d) => d.date), [fixtureDate(1)]);
+});
+
+test("malformed and impossible dates are invalid-argument", async () => {
+  const request = { latitude: 41.3, longitude: -87.3, units: "metric" as const, refresh: true };
+  await assert.rejects(getWeatherForecast({ ...request, date: "2026/10/14" }), withCode("invalid-argument", "YYYY-MM-DD"));
+  await assert.rejects(getWeatherForecast({ ...request, date: "14-10-2026" }), withCode("invalid-argument", "YYYY-MM-DD"));
+  await assert.rejects(getWeatherForecast({ ...request, date: 20261014 as unknown as string }), withCode("invalid-argument", "YYYY-MM-DD"));
+  await assert.rejects(getWeatherForecast({ ...request, date: "2024-02-30" }), withCode("invalid-argument", "not a valid calendar date"));
+  await assert.rejects(getWeatherForecast({ ...request, date: "2026-13-01" }), withCode("invalid-argument", "not a valid calendar date"));
+});
+
+test("a date outside the forecast window is not-found and names the window", async () => {
+  forecastList = [0, 1, 2].map((offset) => reading(offset, 12));
+
+  await assert.rejects(
+    getWeatherForecast({ latitude: 41.3, longitude: -87.3, units: "metric", refresh: true, date: fixtureDate(9) }),
+    withCode("not-found", `(available: ${fixtureDate(0)} to ${fixtureDate(2)})`)
+  );
+});
+
+// Helper function to build a day of 3-hourly readings whose details identify the hour they were taken
+function dayOfReadings(dayOffset: number, hoursUtc: number[]): OpenWeatherForecastItem[] {
+  return hoursUtc.map((hour) => reading(dayOffset, hour, {
+    main: { temp: 10 + hour / 3, feels_like: 10, temp_min: 10, temp_max: 20, humidity: hour, pressure: 1000 + hour },
+    weather: [{ id: 800 + hour, description: `reading at ${hour}`, icon: "01d" }],
+  }));
+}
+
+test("daily details come from the reading closest to local noon", async () => {
+  forecastList = dayOfReadings(0, [0, 3, 6, 9, 12, 15, 18, 21]);
+
+  const [day] = (await getWeatherForecast({ latitude: 41.4, longitude: -87.4, units: "metric", refresh: true })).data.days;
+  assert.equal(day.condition, "reading at 12");
+  assert.equal(day.humidity, 12);
+  assert.equal(day.pressure, 1012);
+  assert.equal(day.highTemp, 17);
+  assert.equal(day.lowTemp, 10);
+});
+
+test("local noon follows the location's time zone, not UTC", async () => {
+  cityTimezone = -5 * HOUR; // Local noon is 17:00 UTC
+  forecastList = dayOfReadings(0, [6, 9, 12, 15, 18, 21]);
+
+  const [day] = (await getWeatherForecast({ latitude: 41.5, longitude: -87.5, units: "metric", refresh: true })).data.days;
+  assert.equal(day.condition, "reading at 18");
+});
+
+test("without a noon reading the nearest one is used, the earlier on a tie", async () => {
+  forecastList = dayOfReadings(0, [6, 9, 15, 21]);
+
+  const [day] = (await getWeatherForecast({ latitude: 41.6, longitude: -87.6, units: "metric", refresh: true })).data.days;
+  assert.equal(day.condition, "reading at 9");
+});
+
+test("days are sorted chronologically before the forecast is cut to five", async () => {
+  forecastList = [6, 2, 0, 5, 3, 1, 4].map((offset) => reading(offset, 12));
+
+  const forecast = await getWeatherForecast({ latitude: 41.7, longitude: -87.7, units: "metric", refresh: true });
+  assert.deepEqual(forecast.data.days.map((d) => d.date), [0, 1, 2, 3, 4].map(fixtureDate));
+
+  const shorter = await getWeatherForecast({ latitude: 41.7, longitude: -87.7, units: "metric", refresh: true, days: 2 });
+  assert.deepEqual(shorter.data.days.map((d) => d.date), [fixtureDate(0), fixtureDate(1)]);
+});
+
+test("readings with an empty weather array give days and hours an unknown condition", async () => {
+  forecastList = [reading(0, 9, { weather: [] }), reading(0, 12, { weather: [] })];
+
+  const forecast = await getWeatherForecast({ latitude: 41.8, longitude: -87.8, units: "metric", refresh: true, granularity: "both" });
+  assert.equal(forecast.data.days.length, 1);
+  assert.equal(forecast.data.days[0].condition, "unknown");
+  assert.equal(forecast.data.days[0].icon, "");
+  assert.equal(forecast.data.days[0].highTemp, 15);
+  assert.deepEqual((forecast.data.hourly || []).map((hour) => [hour.condition, hour.icon]), [["unknown", ""], ["unknown", ""]]);
+});
+
+test("feels-like highs and lows come from the day's readings, falling back to the air temperature", async () => {
+  const main = (temp: number, feelsLike?: number) => ({ temp, feels_like: feelsLike, temp_min: temp, temp_max: temp, humidity: 60, pressure: 1012 });
+  forecastList = [
+    reading(0, 6, { main: main(8, 4.6) }),
+    reading(0, 12, { main: main(14, 12.2) }),
+    reading(0, 18, { main: main(11, 9) }),
+    reading(1, 6, { main: main(9) }),
+    reading(1, 12, { main: main(16.4) }),
+  ];
+
+  const [first, second] = (await getWeatherForecast({ latitude: 41.9, longitude: -87.9, units: "metric", refresh: true })).data.days;
+  assert.deepEqual([first.feelsLikeHigh, first.feelsLikeLow], [12, 5]);
+  assert.deepEqual([first.highTemp, first.lowTemp], [14, 8]);
+  assert.deepEqual([second.feelsLikeHigh, second.feelsLikeLow], [16, 9]);
+});
+
+test("dual adds each day's highs, lows and wind in the other unit system", async () => {
+  const main = { temp: 20, feels_like: 19, temp_min: 20, temp_max: 20, humidity: 60, pressure: 1012 };
+  forecastList = [reading(0, 6, { main: { ...main, temp: 10 } }), reading(0, 12, { main, wind: { speed: 5, deg: 90 } })];
+
+  const dual = await getWeatherForecast({ latitude: 42.1, longitude: -88.1, units: "metric", refresh: true, dual: true });
+  assert.deepEqual(dual.data.days[0].alternateUnits, { units: "imperial", highTemp: 68, lowTemp: 50, windSpeed: 11.2 });
+
+  const plain = await getWeatherForecast({ latitude: 42.1, longitude: -88.1, units: "metric", refresh: true });
+  assert.equal(plain.data.days[0].alternateUnits, undefined);
+});
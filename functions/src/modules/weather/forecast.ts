@@ -1,11 +1,19 @@
 // Weather forecast logic
 
-import axios from "axios";
 import * as logger from "firebase-functions/logger";
-import { ForecastRequest, ForecastData, ForecastResponse, OpenWeatherForecastResponse, OpenWeatherForecastItem, ForecastDay } from "../../types";
-import { getCacheKey, getCachedWeatherData, setCachedWeatherData } from "../shared/cache";
-import { getDetailedLocation } from "../shared/location";
-import { CACHE_TTL, weatherApiKey } from "../../config";
+import { HttpsError } from "firebase-functions/v2/https";
+import { ForecastRequest, ForecastData, ForecastResponse, OpenWeatherForecastResponse, OpenWeatherForecastItem, OpenWeatherOneCallResponse, ForecastDay, ForecastHour, ForecastGranularity, TemperatureTrend } from "../../types";
+import { getCacheGeneration, getCachedWeatherData, getForecastCacheKey, setCachedWeatherData } from "../shared/cache";
+import { getDetailedLocation, validateCoordinates } from "../shared/location";
+import { weatherHttpClient } from "../shared/http";
+import { convertTemperature, convertWindSpeed, normalizeUnits, Units } from "../shared/units";
+import { memoizeForRequest, requestLogFields } from "../shared/requestContext";
+import { CACHE_TTL, FORECAST_DAYS_BY_API_VERSION, weatherApiKey, WEATHER_CONFIG } from "../../config";
+import { getCurrentWeather, primaryCondition } from "./current";
+import { degradation } from "./degraded";
+import { convertForecastData, otherUnits } from "./conversion";
+import { fetchOneCall, isOneCallMode } from "./onecall";
+import { assertWeatherProviderAvailable, normalizeWeatherError } from "./errors";
 
 // Helper function to convert wind degrees to direction
 function getWindDirection(degrees: number): string {
@@ -14,18 +22,222 @@ function getWindDirection(degrees: number): string {
   return directions[index];
 }
 
-// Get weather forecast data
+// Helper function to check a reading carries wind and condition data - some list items send zeros and no weather
+function hasReadingDetails(item: OpenWeatherForecastItem): boolean {
+  const hasWind = !!item.wind && (item.wind.speed > 0 || item.wind.deg > 0);
+  return hasWind && !!item.weather && item.weather.length > 0;
+}
+
+// How far ahead the hourly view reaches
+const HOURLY_WINDOW_MS = 48 * 60 * 60 * 1000;
+
+// Helper function to validate the requested granularity
+function resolveGranularity(granularity: unknown): ForecastGranularity {
+  if (granularity === undefined) {
+    return "daily";
+  }
+  if (granularity !== "hourly" && granularity !== "daily" && granularity !== "both") {
+    throw new HttpsError("invalid-argument", `granularity must be hourly, daily or both, got ${JSON.stringify(granularity)}`);
+  }
+  return granularity;
+}
+
+// Helper function to map One Call 3.0 daily and hourly entries into our forecast (days keyed by local date)
+function toOneCallForecast(oneCall: OpenWeatherOneCallResponse, units: Units, location: string): ForecastData {
+  const timezoneOffset = oneCall.timezone_offset || 0;
+  const localDate = (dt: number) => new Date((dt + timezoneOffset) * 1000);
+  const today = localDate(Math.floor(Date.now() / 1000)).toISOString().split("T")[0];
+
+  const days: ForecastDay[] = (oneCall.daily || [])
+    .filter((day) => localDate(day.dt).toISOString().split("T")[0] >= today)
+    .slice(0, FORECAST_DAYS_BY_API_VERSION["3.0"])
+    .map((day) => {
+      const feelsLike = [day.feels_like.morn, day.feels_like.day, day.feels_like.eve, day.feels_like.night];
+      return {
+        date: localDate(day.dt).toISOString().split("T")[0],
+        dayName: localDate(day.dt).toLocaleDateString("en-US", { weekday: "long", timeZone: "UTC" }), // Already shifted
+        highTemp: Math.round(day.temp.max),
+        lowTemp: Math.round(day.temp.min),
+        feelsLikeHigh: Math.round(Math.max(...feelsLike)),
+        feelsLikeLow: Math.round(Math.min(...feelsLike)),
+        condition: primaryCondition(day.weather).description,
+        icon: primaryCondition(day.weather).icon,
+        humidity: Math.round(day.humidity),
+        windSpeed: Math.round((day.wind_speed || 0) * 10) / 10,
+        windDirection: getWindDirection(day.wind_deg || 0),
+        pressure: units === "imperial" ? Math.round(day.pressure * 0.02953 * 100) / 100 : Math.round(day.pressure),
+        precipitation: Math.round((day.pop || 0) * 100),
+      };
+    });
+
+  // One Call's hourly entries are 1 hour apart and already cover the next 48 hours
+  const now = Date.now();
+  const hourly: ForecastHour[] = (oneCall.hourly || [])
+    .filter((hour) => (hour.dt + 60 * 60) * 1000 > now && hour.dt * 1000 <= now + HOURLY_WINDOW_MS)
+    .map((hour) => ({
+      time: new Date(hour.dt * 1000).toISOString(),
+      temperature: Math.round(hour.temp),
+      condition: primaryCondition(hour.weather).description,
+      icon: primaryCondition(hour.weather).icon,
+      precipitation: Math.round((hour.pop || 0) * 100),
+    }));
+
+  return { location, days, hourly };
+}
+
+// Helper function to get the API key from Firebase Secret Manager, or the environment for local development
+function getForecastApiKey(): string {
+  try {
+    const apiKey = weatherApiKey.value();
+    logger.info("Using secret manager API key for forecast");
+    return apiKey;
+  } catch {
+    logger.info("Secret not available, trying environment variable for forecast");
+    return process.env.WEATHER_API_KEY || "";
+  }
+}
+
+// Most forecast days this deployment actually serves: One Call's only when it will be called (a key is
+// configured), otherwise the 2.5 forecast's - the key-less mock forecast is 5 days too
+function maxForecastDays(apiKey: string): number {
+  return FORECAST_DAYS_BY_API_VERSION[apiKey && isOneCallMode() ? "3.0" : "2.5"];
+}
+
+// Helper function to validate the requested number of days against what the deployment can serve
+function resolveForecastDays(days: unknown, apiKey: string): number {
+  const maxDays = maxForecastDays(apiKey);
+  if (days === undefined) {
+    return maxDays;
+  }
+  if (typeof days !== "number" || !Number.isInteger(days) || days < 1 || days > maxDays) {
+    throw new HttpsError(
+      "invalid-argument",
+      `days must be an integer from 1 to ${maxDays} with this weather configuration, got ${JSON.stringify(days)}`,
+      { code: "forecast_days_exceed_plan", maxDays }
+    );
+  }
+  return days;
+}
+
+// Helper function to drop the views the caller didn't ask for - the cached forecast always holds both
+function applyGranularity(forecast: ForecastResponse, granularity: ForecastGranularity): ForecastResponse {
+  if (granularity === "both") {
+    return forecast;
+  }
+  if (granularity === "hourly") {
+    return { ...forecast, data: { ...forecast.data, days: [] } };
+  }
+  const daily = { ...forecast.data };
+  delete daily.hourly;
+  return { ...forecast, data: daily };
+}
+
+// Helper function to add the other unit system's temperatures and wind to each day (never cached)
+function withAlternateUnits(forecast: ForecastResponse, units: Units): ForecastResponse {
+  const alternate: Units = units === "imperial" ? "metric" : "imperial";
+  const days = forecast.data.days.map((day) => ({
+    ...day,
+    alternateUnits: {
+      units: alternate,
+      highTemp: convertTemperature(day.highTemp, units, alternate),
+      lowTemp: convertTemperature(day.lowTemp, units, alternate),
+      windSpeed: convertWindSpeed(day.windSpeed, units, alternate),
+    },
+  }));
+  return { ...forecast, data: { ...forecast.data, days } };
+}
+
+// Average change (in degrees) beyond which the overall trend is no longer "steady"
+const TREND_THRESHOLD = 2;
+
+// Helper function to annotate forecast days with their temperature change relative to now
+export function applyTemperatureTrend(forecast: ForecastData, currentTemp: number): ForecastData {
+  // Copy rather than mutate - the forecast may be the in-memory cached object
+  const days = forecast.days.map((day) => ({
+    ...day,
+    tempDelta: Math.round((day.highTemp + day.lowTemp) / 2 - currentTemp),
+  }));
+
+  const averageDelta = days.length > 0
+    ? days.reduce((sum, day) => sum + day.tempDelta, 0) / days.length
+    : 0;
+
+  let trend: TemperatureTrend = "steady";
+  if (averageDelta >= TREND_THRESHOLD) {
+    trend = "warming";
+  } else if (averageDelta <= -TREND_THRESHOLD) {
+    trend = "cooling";
+  }
+
+  return { ...forecast, days, trend };
+}
+
+// Helper function to validate a requested YYYY-MM-DD date, rejecting impossible ones like 2024-02-30
+function validateForecastDate(date: unknown): string {
+  if (typeof date !== "string" || !/^\d{4}-\d{2}-\d{2}$/.test(date)) {
+    throw new HttpsError("invalid-argument", `date must be in YYYY-MM-DD format, got ${JSON.stringify(date)}`);
+  }
+  const parsed = new Date(`${date}T00:00:00Z`);
+  if (isNaN(parsed.getTime()) || parsed.toISOString().slice(0, 10) !== date) {
+    throw new HttpsError("invalid-argument", `date is not a valid calendar date: ${date}`);
+  }
+  return date;
+}
+
+// Helper function to narrow a forecast to a single requested day
+function selectForecastDate(forecast: ForecastResponse, date: string): ForecastResponse {
+  const day = forecast.data.days.find((candidate) => candidate.date === date);
+  if (!day) {
+    const days = forecast.data.days;
+    const window = days.length > 0 ? ` (available: ${days[0].date} to ${days[days.length - 1].date})` : "";
+    throw new HttpsError("not-found", `No forecast available for ${date}${window}`);
+  }
+  return { ...forecast, data: { ...forecast.data, days: [day] } };
+}
+
+// Get weather forecast data, optionally annotated with the trend against current conditions
 export async function getWeatherForecast(request: ForecastRequest): Promise<ForecastResponse> {
+  const date = request.date !== undefined ? validateForecastDate(request.date) : undefined;
+  const granularity = resolveGranularity(request.granularity);
+  const apiKey = getForecastApiKey();
+  const dayCount = resolveForecastDays(request.days, apiKey);
+  const memoKey = `forecast:${JSON.stringify([request.latitude, request.longitude, normalizeUnits(request.units), !!request.refresh])}`;
+  const fetched = await memoizeForRequest(memoKey, () => fetchWeatherForecast(request, apiKey));
+  const forecast = { ...fetched, data: { ...fetched.data, days: fetched.data.days.slice(0, dayCount) } };
+  const narrowed = applyGranularity(date ? selectForecastDate(forecast, date) : forecast, granularity);
+  const selected = request.dual ? withAlternateUnits(narrowed, normalizeUnits(request.units)) : narrowed;
+
+  if (!request.includeTrend) {
+    return selected;
+  }
+
+  try {
+    // Served from the current-weather cache in the common case
+    const current = await getCurrentWeather({
+      latitude: request.latitude,
+      longitude: request.longitude,
+      units: request.units,
+      refresh: request.refresh,
+    });
+    return { ...selected, data: applyTemperatureTrend(selected.data, current.data.temperature) };
+  } catch (error) {
+    logger.warn("Skipping forecast trend, current weather unavailable:", error);
+    return { ...selected, ...degradation(["trend_unavailable"]) };
+  }
+}
+
+// Fetch the forecast from cache or OpenWeatherMap
+async function fetchWeatherForecast(request: ForecastRequest, apiKey: string): Promise<ForecastResponse> {
   try {
-    const { latitude, longitude, units = "metric" } = request;
+    const { latitude, longitude } = request;
+    const units = normalizeUnits(request.units);
 
-    if (!latitude || !longitude) {
-      throw new Error("Latitude and longitude are required");
-    }
+    validateCoordinates(latitude, longitude);
 
-    // Check cache first
-    const cacheKey = getCacheKey("forecast", latitude, longitude, units);
-    const cachedData = await getCachedWeatherData(cacheKey, CACHE_TTL.FORECAST);
+    // Check cache first, unless the caller asked for a fresh reading
+    const cacheKey = getForecastCacheKey(latitude, longitude, units);
+    const cacheGeneration = getCacheGeneration(cacheKey);
+    const cachedData = request.refresh ? null : await getCachedWeatherData(cacheKey, CACHE_TTL.FORECAST);
     
     if (cachedData) {
       logger.info(`Returning cached forecast data for ${(cachedData as ForecastData).location}`);
@@ -33,20 +245,33 @@ export async function getWeatherForecast(request: ForecastRequest): Promise<Fore
         success: true,
         data: cachedData as ForecastData,
         cached: true,
+        ...degradation([]),
       };
     }
 
-    // Get API key from Firebase Secret Manager or environment variable
-    let apiKey: string;
-    try {
-      apiKey = weatherApiKey.value();
-      logger.info("Using secret manager API key for forecast");
-    } catch {
-      // Fallback to environment variable for local development
-      logger.info("Secret not available, trying environment variable for forecast");
-      apiKey = process.env.WEATHER_API_KEY || "";
+    // The same forecast cached in the other unit system is converted rather than re-fetched
+    const otherCached = request.refresh ? null : await getCachedWeatherData(getForecastCacheKey(latitude, longitude, otherUnits(units)), CACHE_TTL.FORECAST);
+    if (otherCached) {
+      const converted = convertForecastData(otherCached as ForecastData, otherUnits(units), units);
+      logger.info(`Returning cached forecast data for ${converted.location}, converted to ${units}`);
+      return {
+        success: true,
+        data: converted,
+        cached: true,
+        ...degradation([]),
+      };
     }
-    
+
+    if (apiKey && isOneCallMode()) {
+      assertWeatherProviderAvailable();
+      logger.info("Calling OpenWeatherMap One Call 3.0 API for forecast");
+      const oneCall = await fetchOneCall(latitude, longitude, units, apiKey.trim());
+      const oneCallForecast = toOneCallForecast(oneCall, units, await getDetailedLocation(latitude, longitude, apiKey));
+      logger.info(`Retrieved ${oneCallForecast.days.length}-day forecast for ${oneCallForecast.location}`);
+      await setCachedWeatherData(cacheKey, oneCallForecast, CACHE_TTL.FORECAST, cacheGeneration);
+      return { success: true, data: oneCallForecast, cached: false, ...degradation([]) };
+    }
+
     let data: OpenWeatherForecastResponse;
     
     if (!apiKey) {
@@ -66,6 +291,7 @@ export async function getWeatherForecast(request: ForecastRequest): Promise<Fore
           dt: Math.floor(date.getTime() / 1000),
           main: { 
             temp: 22 + (i * 2), // Vary temperature slightly
+            feels_like: 21 + (i * 2),
             temp_min: 18 + (i * 2), 
             temp_max: 25 + (i * 2), 
             humidity: 65 - (i * 5), 
@@ -86,43 +312,48 @@ export async function getWeatherForecast(request: ForecastRequest): Promise<Fore
       };
     } else {
       // Use OpenWeatherMap 5-day forecast API
+      assertWeatherProviderAvailable();
       logger.info("Calling OpenWeatherMap forecast API");
-      const url = "https://api.openweathermap.org/data/2.5/forecast";
+      const url = `${WEATHER_CONFIG.BASE_URL}/forecast`;
       const params = {
         lat: latitude,
         lon: longitude,
         appid: apiKey.trim(),
-        units: units || "metric",
+        units,
       };
 
-      const response = await axios.get(url, {params});
+      const response = await weatherHttpClient.get(url, {params});
       data = response.data;
     }
 
+    // Bound the work done on a malformed or unexpectedly large response
+    const items = (data.list || []).slice(0, WEATHER_CONFIG.MAX_FORECAST_ITEMS);
+    if (data.list && data.list.length > items.length) {
+      logger.warn(`Forecast response had ${data.list.length} entries, processing the first ${items.length}`);
+    }
+
     // Group forecast data by day and find high/low temps
     const dailyData: { [key: string]: OpenWeatherForecastItem[] } = {};
     
-    data.list.forEach((item: OpenWeatherForecastItem) => {
+    items.forEach((item: OpenWeatherForecastItem) => {
       // Use the timezone offset from the API response to get correct local date
       const timezoneOffset = data.city.timezone || 0; // timezone offset in seconds
       const localTime = new Date((item.dt + timezoneOffset) * 1000);
-      const date = localTime.toDateString();
+      const date = localTime.toISOString().split("T")[0]; // YYYY-MM-DD in the location's time zone
       if (!dailyData[date]) {
         dailyData[date] = [];
       }
       dailyData[date].push(item);
     });
 
-    // Convert to our format - take first 5 days, but filter out past dates
-    const today = new Date();
-    today.setHours(0, 0, 0, 0); // Start of today
+    // Convert to our format - sort chronologically (entries may arrive out of order), drop past
+    // dates in the location's time zone, then take as many days as the 2.5 forecast serves
+    const today = new Date(Date.now() + (data.city.timezone || 0) * 1000).toISOString().split("T")[0];
     
     const forecastDays: ForecastDay[] = Object.keys(dailyData)
-      .filter(dateStr => {
-        const date = new Date(dateStr);
-        return date >= today; // Only include today and future dates
-      })
-      .slice(0, 5)
+      .sort()
+      .filter(dateStr => dateStr >= today) // Only include today and future dates
+      .slice(0, FORECAST_DAYS_BY_API_VERSION["2.5"])
       .map(dateStr => {
         const dayData = dailyData[dateStr];
         
@@ -130,12 +361,20 @@ export async function getWeatherForecast(request: ForecastRequest): Promise<Fore
         const temps = dayData.map((item: OpenWeatherForecastItem) => item.main.temp);
         const highTemp = Math.round(Math.max(...temps));
         const lowTemp = Math.round(Math.min(...temps));
+        const feelsLike = dayData.map((item: OpenWeatherForecastItem) => item.main.feels_like ?? item.main.temp);
+        const feelsLikeHigh = Math.round(Math.max(...feelsLike));
+        const feelsLikeLow = Math.round(Math.min(...feelsLike));
         
-        // Use midday data (around 12pm) for condition and other details
-        const middayData = dayData.find((item: OpenWeatherForecastItem) => {
-          const hour = new Date(item.dt * 1000).getHours();
-          return hour >= 10 && hour <= 14;
-        }) || dayData[Math.floor(dayData.length / 2)];
+        // Use the reading closest to 12:00 local time for condition and other details
+        const timezoneOffset = data.city.timezone || 0;
+        const minutesFromNoon = (item: OpenWeatherForecastItem) => {
+          const localTime = new Date((item.dt + timezoneOffset) * 1000);
+          return Math.abs(localTime.getUTCHours() * 60 + localTime.getUTCMinutes() - 12 * 60);
+        };
+        // Readings that omit wind (sent as zeros) or conditions only count when no reading that day has them
+        const withDetails = dayData.filter(hasReadingDetails);
+        const middayData = (withDetails.length > 0 ? withDetails : dayData).reduce((closest, item) =>
+          minutesFromNoon(item) < minutesFromNoon(closest) ? item : closest);
         
         // Convert pressure based on units
         const pressureInHPa = middayData.main.pressure;
@@ -144,24 +383,37 @@ export async function getWeatherForecast(request: ForecastRequest): Promise<Fore
           : Math.round(pressureInHPa);
 
         // Use the same timezone-aware date for both date and dayName
-        const timezoneOffset = data.city.timezone || 0;
         const localDate = new Date((middayData.dt + timezoneOffset) * 1000);
 
         return {
-          date: localDate.toISOString().split("T")[0], // Use timezone-aware date
-          dayName: localDate.toLocaleDateString("en-US", { weekday: "long" }),
+          date: dateStr, // Timezone-aware grouping key
+          dayName: localDate.toLocaleDateString("en-US", { weekday: "long", timeZone: "UTC" }), // localDate is already shifted
           highTemp,
           lowTemp,
-          condition: middayData.weather[0].description,
-          icon: middayData.weather[0].icon,
+          feelsLikeHigh,
+          feelsLikeLow,
+          condition: primaryCondition(middayData.weather).description,
+          icon: primaryCondition(middayData.weather).icon,
           humidity: Math.round(middayData.main.humidity),
-          windSpeed: Math.round(middayData.wind.speed * 10) / 10,
-          windDirection: getWindDirection(middayData.wind.deg),
+          windSpeed: Math.round((middayData.wind?.speed || 0) * 10) / 10,
+          windDirection: getWindDirection(middayData.wind?.deg || 0),
           pressure: convertedPressure,
           precipitation: Math.round((middayData.pop || 0) * 100),
         };
       });
 
+    // Hourly view straight from the 3-hour entries, from the current slot onwards
+    const now = Date.now();
+    const hourly: ForecastHour[] = items
+      .filter((item) => (item.dt + 3 * 60 * 60) * 1000 > now && item.dt * 1000 <= now + HOURLY_WINDOW_MS)
+      .map((item) => ({
+        time: new Date(item.dt * 1000).toISOString(),
+        temperature: Math.round(item.main.temp),
+        condition: primaryCondition(item.weather).description,
+        icon: primaryCondition(item.weather).icon,
+        precipitation: Math.round((item.pop || 0) * 100),
+      }));
+
     // Get detailed location information for forecast
     const detailedLocation = apiKey ? 
       await getDetailedLocation(latitude, longitude, apiKey) : 
@@ -170,20 +422,22 @@ export async function getWeatherForecast(request: ForecastRequest): Promise<Fore
     const forecastData: ForecastData = {
       location: detailedLocation,
       days: forecastDays,
+      hourly,
     };
 
-    logger.info(`Retrieved 5-day forecast for ${forecastData.location}`);
+    logger.info(`Retrieved ${forecastDays.length}-day forecast for ${forecastData.location}`);
 
     // Cache the data
-    await setCachedWeatherData(cacheKey, forecastData, CACHE_TTL.FORECAST);
+    await setCachedWeatherData(cacheKey, forecastData, CACHE_TTL.FORECAST, cacheGeneration);
 
     return {
       success: true,
       data: forecastData,
       cached: false,
+      ...degradation([]),
     };
   } catch (error) {
-    logger.error("Error fetching weather forecast:", error);
-    throw new Error(`Failed to fetch weather forecast: ${error instanceof Error ? error.message : "Unknown error"}`);
+    logger.error("Error fetching weather forecast:", error, requestLogFields());
+    throw normalizeWeatherError(error, "Failed to fetch weather forecast");
   }
 }
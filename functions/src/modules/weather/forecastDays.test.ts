@@ -0,0 +1,51 @@
+// Forecast length cap tests - validation runs before any provider call, so nothing is stubbed
+
+import { afterEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { HttpsError } from "firebase-functions/v2/https";
+import { WEATHER_CONFIG } from "../../config";
+import { getWeatherForecast } from "./forecast";
+
+const originalVersion = WEATHER_CONFIG.API_VERSION;
+afterEach(() => {
+  WEATHER_CONFIG.API_VERSION = originalVersion;
+  delete process.env.weather_api_key;
+});
+
+// Helper function to read the advertised cap from a rejected request
+async function rejectedMaxDays(days: unknown): Promise<number> {
+  try {
+    await getWeatherForecast({ latitude: 0, longitude: 0, days } as Parameters<typeof getWeatherForecast>[0]);
+  } catch (error) {
+    assert.ok(error instanceof HttpsError);
+    assert.equal(error.code, "invalid-argument");
+    const details = error.details as { code: string; maxDays: number };
+    assert.equal(details.code, "forecast_days_exceed_plan");
+    return details.maxDays;
+  }
+  throw new Error(`days=${JSON.stringify(days)} was accepted`);
+}
+
+test("2.5 caps forecasts at five days", async () => {
+  WEATHER_CONFIG.API_VERSION = "2.5";
+  process.env.weather_api_key = "test-key";
+  assert.equal(await rejectedMaxDays(6), 5);
+});
+
+test("3.0 with a key caps forecasts at eight days", async () => {
+  WEATHER_CONFIG.API_VERSION = "3.0";
+  process.env.weather_api_key = "test-key";
+  assert.equal(await rejectedMaxDays(9), 8);
+});
+
+test("3.0 without a key caps at the five days the mock forecast serves", async () => {
+  WEATHER_CONFIG.API_VERSION = "3.0";
+  assert.equal(await rejectedMaxDays(8), 5);
+});
+
+test("days must be a positive integer", async () => {
+  WEATHER_CONFIG.API_VERSION = "2.5";
+  for (const days of [0, -1, 2.5, "3", null]) {
+    assert.equal(await rejectedMaxDays(days), 5);
+  }
+});
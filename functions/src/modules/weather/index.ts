@@ -2,3 +2,12 @@
 
 export * from "./current";
 export * from "./forecast";
+export * from "./summary";
+export * from "./precipitation";
+export * from "./degraded";
+export * from "./errors";
+export * from "./views";
+export * from "./batch";
+export * from "./conversion";
+export * from "./airQuality";
+export * from "./onecall";
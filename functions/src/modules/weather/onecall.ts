@@ -0,0 +1,63 @@
+// One Call 3.0 - used for current weather and forecasts when WEATHER_CONFIG.API_VERSION is "3.0"
+
+import { OpenWeatherCurrentResponse, OpenWeatherOneCallResponse, WeatherAlert } from "../../types";
+import { weatherHttpClient } from "../shared/http";
+import { memoizeForRequest } from "../shared/requestContext";
+import { Units } from "../shared/units";
+import { WEATHER_CONFIG } from "../../config";
+
+// Whether the deployment is configured for One Call 3.0
+export function isOneCallMode(): boolean {
+  return WEATHER_CONFIG.API_VERSION === "3.0";
+}
+
+// Fetch the One Call response for a location - shared within a request, so current weather and the
+// forecast for the same place cost one provider call
+export function fetchOneCall(latitude: number, longitude: number, units: Units, apiKey: string): Promise<OpenWeatherOneCallResponse> {
+  return memoizeForRequest(`onecall:${latitude}:${longitude}:${units}`, async () => {
+    const response = await weatherHttpClient.get<OpenWeatherOneCallResponse>(WEATHER_CONFIG.ONECALL_URL, {
+      params: {
+        lat: latitude,
+        lon: longitude,
+        exclude: "minutely",
+        appid: apiKey,
+        units,
+      },
+    });
+    return response.data;
+  });
+}
+
+// Map One Call's current block onto the 2.5 /weather shape, so the usual transform applies. The place name
+// comes from reverse geocoding either way, so name and country are left empty
+export function toCurrentResponse(oneCall: OpenWeatherOneCallResponse): { data: OpenWeatherCurrentResponse; uvIndex: number | null } {
+  const { current } = oneCall;
+  return {
+    data: {
+      main: {
+        temp: current.temp,
+        feels_like: current.feels_like,
+        temp_min: current.temp,
+        temp_max: current.temp,
+        humidity: current.humidity,
+        pressure: current.pressure,
+      },
+      weather: current.weather || [],
+      wind: { speed: current.wind_speed, deg: current.wind_deg },
+      name: "",
+      sys: { country: "" },
+    },
+    uvIndex: typeof current.uvi === "number" ? current.uvi : null,
+  };
+}
+
+// Map One Call alerts to ours
+export function toWeatherAlerts(oneCall: OpenWeatherOneCallResponse): WeatherAlert[] {
+  return (oneCall.alerts || []).map((alert) => ({
+    event: alert.event,
+    sender: alert.sender_name,
+    start: new Date(alert.start * 1000).toISOString(),
+    end: new Date(alert.end * 1000).toISOString(),
+    description: alert.description,
+  }));
+}
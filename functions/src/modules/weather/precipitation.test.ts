@@ -0,0 +1,61 @@
+// Precipitation threshold tests - classification at the cutoffs, and validation of the configured values
+
+import { afterEach, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { loadPrecipitationThresholds } from "../../config";
+import { classifyPrecipitation } from "./precipitation";
+
+const saved = {
+  low: process.env.PRECIP_THRESHOLD_LOW,
+  medium: process.env.PRECIP_THRESHOLD_MEDIUM,
+  high: process.env.PRECIP_THRESHOLD_HIGH,
+};
+
+// Helper function to set (or clear, with undefined) the three threshold variables
+function setThresholds(low?: string, medium?: string, high?: string): void {
+  const values: [string, string | undefined][] = [["PRECIP_THRESHOLD_LOW", low], ["PRECIP_THRESHOLD_MEDIUM", medium], ["PRECIP_THRESHOLD_HIGH", high]];
+  values.forEach(([name, value]) => {
+    if (value === undefined) delete process.env[name]; else process.env[name] = value;
+  });
+}
+
+afterEach(() => {
+  setThresholds(saved.low, saved.medium, saved.high);
+});
+
+test("the default cutoffs are 10, 30 and 60 percent, each inclusive", () => {
+  setThresholds();
+  const thresholds = loadPrecipitationThresholds();
+  assert.deepEqual(thresholds, { LOW: 10, MEDIUM: 30, HIGH: 60 });
+
+  assert.equal(classifyPrecipitation(0, thresholds), "none");
+  assert.equal(classifyPrecipitation(9, thresholds), "none");
+  assert.equal(classifyPrecipitation(10, thresholds), "low");
+  assert.equal(classifyPrecipitation(29, thresholds), "low");
+  assert.equal(classifyPrecipitation(30, thresholds), "medium");
+  assert.equal(classifyPrecipitation(59, thresholds), "medium");
+  assert.equal(classifyPrecipitation(60, thresholds), "high");
+  assert.equal(classifyPrecipitation(100, thresholds), "high");
+});
+
+test("configured cutoffs replace the defaults", () => {
+  setThresholds("20", "50", "80");
+  const thresholds = loadPrecipitationThresholds();
+  assert.deepEqual(thresholds, { LOW: 20, MEDIUM: 50, HIGH: 80 });
+  assert.equal(classifyPrecipitation(15, thresholds), "none");
+  assert.equal(classifyPrecipitation(60, thresholds), "medium");
+  assert.equal(classifyPrecipitation(80, thresholds), "high");
+});
+
+test("out-of-range, non-numeric and non-ascending cutoffs are rejected at load", () => {
+  setThresholds("-5", "30", "60");
+  assert.throws(() => loadPrecipitationThresholds(), /between 0 and 100/);
+  setThresholds("10", "30", "120");
+  assert.throws(() => loadPrecipitationThresholds(), /between 0 and 100/);
+  setThresholds("ten", "30", "60");
+  assert.throws(() => loadPrecipitationThresholds(), /between 0 and 100/);
+  setThresholds("30", "30", "60");
+  assert.throws(() => loadPrecipitationThresholds(), /ascending/);
+  setThresholds("10", "70", "60");
+  assert.throws(() => loadPrecipitationThresholds(), /ascending/);
+});
@@ -0,0 +1,19 @@
+// Precipitation classification shared by summaries and other consumers
+
+import { PRECIPITATION_THRESHOLDS } from "../../config";
+
+export type PrecipitationLevel = "none" | "low" | "medium" | "high";
+
+// Classify a precipitation probability (0-100) against the configured thresholds
+export function classifyPrecipitation(probability: number, thresholds = PRECIPITATION_THRESHOLDS): PrecipitationLevel {
+  if (probability >= thresholds.HIGH) {
+    return "high";
+  }
+  if (probability >= thresholds.MEDIUM) {
+    return "medium";
+  }
+  if (probability >= thresholds.LOW) {
+    return "low";
+  }
+  return "none";
+}
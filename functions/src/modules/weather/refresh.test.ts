@@ -0,0 +1,85 @@
+// Refresh tests - a cache hit is served from Firestore (emulator, npm test) until the caller asks for a refresh
+
+import { after, before, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { CACHE_TTL, WEATHER_CONFIG } from "../../config";
+import { deleteCachedWeatherData, getCacheKey, getCachedWeatherData, getLocationCacheKeys } from "../shared/cache";
+import { weatherHttpClient } from "../shared/http";
+import { getCurrentWeather } from "./current";
+
+const originalGet = weatherHttpClient.get;
+const originalVersion = WEATHER_CONFIG.API_VERSION;
+let upstreamCalls = 0;
+
+before(() => {
+  process.env.weather_api_key = "test-key";
+  WEATHER_CONFIG.API_VERSION = "2.5";
+  weatherHttpClient.get = (async (url: string) => {
+    if (url === `${WEATHER_CONFIG.BASE_URL}/weather`) {
+      upstreamCalls++;
+      return {
+        status: 200,
+        headers: {},
+        data: {
+          main: { temp: 10 + upstreamCalls, feels_like: 9, temp_min: 8, temp_max: 12, humidity: 50, pressure: 1015 },
+          weather: [{ id: 800, description: "clear sky", icon: "01d" }],
+          wind: { speed: 2, deg: 90 },
+          name: "Refreshville",
+          sys: { country: "US" },
+        },
+      };
+    }
+    if (url.includes("/geo/1.0/reverse")) {
+      return { status: 200, headers: {}, data: [{ name: "Refreshville", country: "US" }] };
+    }
+    throw new Error(`Unexpected weather request: ${url}`);
+  }) as unknown as typeof weatherHttpClient.get;
+});
+
+after(() => {
+  weatherHttpClient.get = originalGet;
+  WEATHER_CONFIG.API_VERSION = originalVersion;
+  delete process.env.weather_api_key;
+});
+
+test("refresh skips a cache hit and fetches from the provider again", async () => {
+  const location = { latitude: 44.5, longitude: -72.6, units: "metric" as const };
+
+  const first = await getCurrentWeather(location);
+  assert.equal(first.cached, false);
+  assert.equal(upstreamCalls, 1);
+
+  const second = await getCurrentWeather(location);
+  assert.equal(second.cached, true);
+  assert.equal(upstreamCalls, 1);
+
+  const refreshed = await getCurrentWeather({ ...location, refresh: true });
+  assert.equal(refreshed.cached, false);
+  assert.equal(upstreamCalls, 2);
+  assert.equal(refreshed.data.temperature, 12);
+});
+
+test("a fetch in flight when its location is cleared doesn't write the old reading back", async () => {
+  const location = { latitude: 44.6, longitude: -72.7, units: "metric" as const };
+  const stubbedGet = weatherHttpClient.get;
+  let release: () => void = () => undefined;
+  const held = new Promise<void>((resolve) => {
+    release = resolve;
+  });
+  weatherHttpClient.get = (async (url: string, config?: Parameters<typeof stubbedGet>[1]) => {
+    await held;
+    return stubbedGet(url, config);
+  }) as unknown as typeof weatherHttpClient.get;
+
+  try {
+    const inFlight = getCurrentWeather(location);
+    await new Promise((resolve) => setTimeout(resolve, 50)); // Past the cache miss, waiting on the provider
+    await deleteCachedWeatherData(getLocationCacheKeys(location.latitude, location.longitude));
+    release();
+    assert.equal((await inFlight).cached, false);
+
+    assert.equal(await getCachedWeatherData(getCacheKey("current", location.latitude, location.longitude, "metric"), CACHE_TTL.CURRENT_WEATHER), null);
+  } finally {
+    weatherHttpClient.get = stubbedGet;
+  }
+});
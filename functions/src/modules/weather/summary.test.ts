@@ -0,0 +1,85 @@
+// Weather summary tests - the template output, and the endpoint over stubbed 2.5 current and forecast responses.
+// Cache writes go to the Firestore emulator (npm test)
+
+import { after, before, test } from "node:test";
+import * as assert from "node:assert/strict";
+import { WEATHER_CONFIG } from "../../config";
+import { ForecastDay, WeatherData } from "../../types";
+import { weatherHttpClient } from "../shared/http";
+import { buildWeatherSummary, getWeatherSummary } from "./summary";
+
+const current: WeatherData = {
+  temperature: 18, condition: "light rain", conditionCode: 500, icon: "10d", humidity: 80, windSpeed: 4,
+  windDirection: "SW", pressure: 1008, uvIndex: null, location: "Portland, Oregon, US", timestamp: "2026-10-14T12:00:00.000Z",
+};
+
+// Helper function to build today's forecast with the given precipitation probability
+function today(precipitation: number): ForecastDay {
+  return {
+    date: "2026-10-14", dayName: "Wednesday", highTemp: 21, lowTemp: 12, feelsLikeHigh: 20, feelsLikeLow: 11,
+    condition: "light rain", icon: "10d", humidity: 80, windSpeed: 4, windDirection: "SW", pressure: 1008, precipitation,
+  };
+}
+
+test("the summary states conditions, temperature and place, then the day's range", () => {
+  assert.equal(buildWeatherSummary(current, today(0), "metric"),
+    "Light rain and 18°C in Portland, Oregon, US. High of 21°C, low of 12°C.");
+  assert.equal(buildWeatherSummary(current, undefined, "imperial"), "Light rain and 18°F in Portland, Oregon, US.");
+});
+
+test("the rain clause follows the precipitation thresholds", () => {
+  assert.match(buildWeatherSummary(current, today(5), "metric"), /low of 12°C\.$/);
+  assert.match(buildWeatherSummary(current, today(10), "metric"), /with a slight chance of rain today \(10%\)\.$/);
+  assert.match(buildWeatherSummary(current, today(30), "metric"), /with a chance of rain today \(30%\)\.$/);
+  assert.match(buildWeatherSummary(current, today(85), "metric"), /with rain likely today \(85%\)\.$/);
+});
+
+const originalGet = weatherHttpClient.get;
+const originalVersion = WEATHER_CONFIG.API_VERSION;
+
+before(() => {
+  process.env.weather_api_key = "test-key";
+  WEATHER_CONFIG.API_VERSION = "2.5";
+  const now = Math.floor(Date.now() / 1000);
+  weatherHttpClient.get = (async (url: string) => {
+    const routes: { [url: string]: unknown } = {
+      [`${WEATHER_CONFIG.BASE_URL}/weather`]: {
+        main: { temp: 9.6, feels_like: 8, temp_min: 9, temp_max: 10, humidity: 70, pressure: 1011 },
+        weather: [{ id: 803, description: "broken clouds", icon: "04d" }],
+        wind: { speed: 5, deg: 200 },
+        name: "Oslo",
+        sys: { country: "NO" },
+      },
+      [`${WEATHER_CONFIG.BASE_URL}/forecast`]: {
+        city: { name: "Oslo", country: "NO", timezone: 0 },
+        list: [8, 11].map((temp, index) => ({
+          dt: now + index * 60, // Both readings fall on today
+          main: { temp, feels_like: temp - 1, temp_min: 8, temp_max: 11, humidity: 70, pressure: 1011 },
+          weather: [{ id: 500, description: "light rain", icon: "10d" }],
+          wind: { speed: 5, deg: 200 },
+          pop: 0.65,
+        })),
+      },
+    };
+    const data = url.includes("/geo/1.0/reverse") ? [{ name: "Oslo", country: "NO" }] : routes[url];
+    if (data === undefined) {
+      throw new Error(`Unexpected weather request: ${url}`);
+    }
+    return { status: 200, data, headers: {} };
+  }) as unknown as typeof weatherHttpClient.get;
+});
+
+after(() => {
+  weatherHttpClient.get = originalGet;
+  WEATHER_CONFIG.API_VERSION = originalVersion;
+  delete process.env.weather_api_key;
+});
+
+test("the endpoint combines current weather with today's forecast and carries degradation through", async () => {
+  const summary = await getWeatherSummary({ latitude: 59.91, longitude: 10.75, units: "metric", refresh: true });
+  assert.equal(summary.success, true);
+  assert.equal(summary.data.location, "Oslo, NO");
+  assert.match(summary.data.summary, /^Broken clouds and 10°C in Oslo, NO\. High of 11°C, low of 8°C, with rain likely today \(65%\)\.$/);
+  assert.equal(summary.cached, false);
+  assert.equal(summary.degradedReason, "uv_unavailable");
+});
@@ -0,0 +1,75 @@
+// Natural-language weather summary logic
+
+import * as logger from "firebase-functions/logger";
+import { ForecastRequest, WeatherData, ForecastDay, WeatherSummaryResponse } from "../../types";
+import { normalizeUnits } from "../shared/units";
+import { requestLogFields } from "../shared/requestContext";
+import { getCurrentWeather } from "./current";
+import { getWeatherForecast } from "./forecast";
+import { classifyPrecipitation } from "./precipitation";
+import { degradation, degradedReasons } from "./degraded";
+import { normalizeWeatherError } from "./errors";
+
+// Helper function to capitalize the provider's lowercase condition text
+function capitalize(text: string): string {
+  return text.charAt(0).toUpperCase() + text.slice(1);
+}
+
+// Helper function to describe the chance of precipitation for the day
+function describePrecipitation(probability: number): string | null {
+  switch (classifyPrecipitation(probability)) {
+  case "high":
+    return `rain likely today (${probability}%)`;
+  case "medium":
+    return `a chance of rain today (${probability}%)`;
+  case "low":
+    return `a slight chance of rain today (${probability}%)`;
+  default:
+    return null;
+  }
+}
+
+// Build a one-or-two-sentence summary from current conditions and today's forecast
+export function buildWeatherSummary(current: WeatherData, today: ForecastDay | undefined, units: string): string {
+  const unit = units === "imperial" ? "°F" : "°C";
+  const sentences = [`${capitalize(current.condition)} and ${current.temperature}${unit} in ${current.location}.`];
+
+  if (today) {
+    const precipitation = describePrecipitation(today.precipitation);
+    sentences.push(
+      `High of ${today.highTemp}${unit}, low of ${today.lowTemp}${unit}` +
+      (precipitation ? `, with ${precipitation}.` : ".")
+    );
+  }
+
+  return sentences.join(" ");
+}
+
+// Get a human-readable weather summary (template-based, no external services)
+export async function getWeatherSummary(request: ForecastRequest): Promise<WeatherSummaryResponse> {
+  try {
+    const units = normalizeUnits(request.units);
+
+    // Both lookups are normally served from cache
+    const [current, forecast] = await Promise.all([
+      getCurrentWeather(request),
+      getWeatherForecast(request),
+    ]);
+
+    const summary = buildWeatherSummary(current.data, forecast.data.days[0], units);
+
+    return {
+      success: true,
+      data: {
+        summary,
+        location: current.data.location,
+        timestamp: current.data.timestamp,
+      },
+      cached: current.cached && forecast.cached,
+      ...degradation([...degradedReasons(current), ...degradedReasons(forecast)]),
+    };
+  } catch (error) {
+    logger.error("Error building weather summary:", error, requestLogFields());
+    throw normalizeWeatherError(error, "Failed to build weather summary");
+  }
+}
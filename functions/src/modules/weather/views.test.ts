@@ -0,0 +1,57 @@
+// Alternative response shape tests - the minimal contract is versioned, so its exact keys are pinned here
+
+import { test } from "node:test";
+import * as assert from "node:assert/strict";
+import { HttpsError } from "firebase-functions/v2/https";
+import { WeatherResponse } from "../../types";
+import { resolveWeatherView, toMinimalWeatherResponse } from "./views";
+
+// Helper function to build a full current-weather response
+function fullResponse(overrides: Partial<WeatherResponse["data"]> = {}): WeatherResponse {
+  return {
+    success: true,
+    data: {
+      temperature: 10,
+      condition: "broken clouds",
+      conditionCode: 803,
+      icon: "04d",
+      humidity: 81,
+      windSpeed: 4.1,
+      windDirection: "SW",
+      pressure: 1012,
+      uvIndex: 2,
+      location: "Oslo, NO",
+      timestamp: "2026-01-01T12:00:00.000Z",
+      ...overrides,
+    },
+    cached: true,
+    degraded: false,
+    degradedReason: null,
+  };
+}
+
+test("views default to full and accept only full or minimal", () => {
+  assert.equal(resolveWeatherView(undefined), "full");
+  assert.equal(resolveWeatherView("full"), "full");
+  assert.equal(resolveWeatherView("minimal"), "minimal");
+  for (const view of ["", "compact", "MINIMAL", 1, null]) {
+    assert.throws(() => resolveWeatherView(view),
+      (error: unknown) => error instanceof HttpsError && error.code === "invalid-argument", String(view));
+  }
+});
+
+test("the minimal view carries exactly the v1 fields", () => {
+  assert.deepEqual(toMinimalWeatherResponse(fullResponse()), {
+    success: true,
+    data: { version: 1, temperature: 10, conditionCode: 803, icon: "04d" },
+    cached: true,
+    degraded: false,
+  });
+});
+
+test("entries cached without a condition code or icon still fit the contract", () => {
+  const legacy = fullResponse();
+  delete (legacy.data as Partial<WeatherResponse["data"]>).conditionCode;
+  delete (legacy.data as Partial<WeatherResponse["data"]>).icon;
+  assert.deepEqual(toMinimalWeatherResponse(legacy).data, { version: 1, temperature: 10, conditionCode: null, icon: "" });
+});
@@ -0,0 +1,27 @@
+// Alternative response shapes for constrained clients
+
+import { HttpsError } from "firebase-functions/v2/https";
+import { MinimalWeatherResponse, WeatherResponse, WeatherView } from "../../types";
+
+// Validate the requested view, defaulting to the full payload
+export function resolveWeatherView(view: unknown): WeatherView {
+  if (view === undefined || view === "full" || view === "minimal") {
+    return view || "full";
+  }
+  throw new HttpsError("invalid-argument", `view must be "full" or "minimal", got ${JSON.stringify(view)}`);
+}
+
+// Map a full current-weather response to the minimal v1 contract
+export function toMinimalWeatherResponse(response: WeatherResponse): MinimalWeatherResponse {
+  return {
+    success: response.success,
+    data: {
+      version: 1,
+      temperature: response.data.temperature,
+      conditionCode: response.data.conditionCode ?? null, // Entries cached before condition codes were stored
+      icon: response.data.icon ?? "",
+    },
+    cached: response.cached,
+    degraded: response.degraded,
+  };
+}
@@ -1,5 +1,9 @@
 // Calendar-specific types and interfaces
 
+import { ForecastDay, ForecastHour } from "./weather";
+
+export type CalendarProviderId = "google" | "microsoft" | "ics";
+
 export interface CalendarEvent {
   id: string;
   summary: string;
@@ -17,10 +21,13 @@ export interface CalendarEvent {
 
 export interface CalendarRequest {
   accessToken: string;
+  provider?: CalendarProviderId;
   calendarId?: string;
   timeMin?: string;
   timeMax?: string;
   maxResults?: number;
+  pageToken?: string; // nextPageToken from the previous response
+  q?: string; // Free-text search over the event's title, description and location
 }
 
 export interface CalendarEventsRequest {
@@ -28,9 +35,28 @@ export interface CalendarEventsRequest {
   timeMax?: string;
   maxResults?: number;
   calendarId?: string;
+  groupBy?: "day";
+  timeZone?: string; // IANA zone for day grouping; defaults to the user's profile timezone
+  pageToken?: string; // nextPageToken from the previous response
+  q?: string; // Free-text search over the event's title, description and location
+}
+
+export interface CalendarSearchRequest {
+  q: string;
+  timeMin?: string; // Defaults to now
+  timeMax?: string; // Defaults to CALENDAR_CONFIG.SEARCH_WINDOW_DAYS ahead; the range is capped at the same length
+  maxResults?: number;
+  pageToken?: string;
+}
+
+export interface CalendarDay {
+  date: string; // YYYY-MM-DD in the grouping time zone
+  events: CalendarEvent[];
 }
 
 export interface CalendarAuthRequest {
+  provider?: CalendarProviderId;
+  icsUrl?: string; // Feed URL, required when provider is "ics"
   googleToken: {
     access_token: string;
     refresh_token?: string;
@@ -49,5 +75,61 @@ export interface CalendarEventsResponse {
   success: boolean;
   events: CalendarEvent[];
   count: number;
+  days?: CalendarDay[]; // Present when groupBy is "day" (groups this page only)
+  nextPageToken?: string | null; // Pass back as pageToken for the next page; null on the last page
   error?: string;
 }
+
+export interface NextEventRequest {
+  latitude?: number;
+  longitude?: number;
+  units?: "metric" | "imperial";
+}
+
+export interface NextEventResponse {
+  success: boolean;
+  event: CalendarEvent | null; // null when nothing is coming up
+  weather: ForecastDay | null;
+}
+
+export interface EventWeatherRequest {
+  units?: "metric" | "imperial";
+  maxResults?: number;
+}
+
+// Weather for an event: the 3-hour slot covering its start when that's within the hourly window, and its day
+export interface WeatherAtEvent {
+  source: "event_location" | "default_location"; // default_location when the event's location couldn't be geocoded
+  latitude: number;
+  longitude: number;
+  atStart: ForecastHour | null; // null for all-day events and starts beyond the hourly window
+  day: ForecastDay | null; // null when the event's date is outside the forecast
+}
+
+export interface CalendarEventWithWeather extends CalendarEvent {
+  weatherAtEvent: WeatherAtEvent | null; // null when no forecast could be fetched for the event's location
+}
+
+export interface EventWeatherResponse {
+  success: boolean;
+  events: CalendarEventWithWeather[];
+  count: number;
+}
+
+// Microsoft Graph calendarView response types
+export interface MicrosoftGraphDateTime {
+  dateTime: string;
+  timeZone: string;
+}
+
+export interface MicrosoftGraphEvent {
+  id: string;
+  subject?: string | null;
+  isAllDay?: boolean;
+  start: MicrosoftGraphDateTime;
+  end: MicrosoftGraphDateTime;
+  location?: {
+    displayName?: string | null;
+  } | null;
+  bodyPreview?: string | null;
+}
@@ -15,6 +15,28 @@ export interface UserProfile {
   };
 }
 
+export interface IntegrationStatus {
+  connected: boolean;
+  needsReconnect: boolean; // Stored credentials can no longer be used without the user reconnecting
+  lastSync: string | null;
+}
+
+export interface IntegrationsStatusResponse {
+  success: boolean;
+  integrations: { [integration: string]: IntegrationStatus };
+}
+
+export interface CacheStats {
+  namespace: string;
+  memoryEntries: number; // This instance only
+  firestoreEntries: number | null; // null when the count query failed
+  memoryHits: number;
+  firestoreHits: number;
+  misses: number;
+  hitRatio: number | null; // null before any lookups
+}
+
 // Re-export specific types
 export * from "./calendar";
 export * from "./weather";
+export * from "./location";
@@ -0,0 +1,28 @@
+// Saved location types and interfaces
+
+export interface SavedLocation {
+  id: string;
+  name: string; // Unique per user, compared case-insensitively
+  latitude: number;
+  longitude: number;
+  isDefault: boolean; // At most one per user
+  createdAt: string;
+  updatedAt: string;
+}
+
+export interface SavedLocationInput {
+  name?: string;
+  latitude?: number;
+  longitude?: number;
+  isDefault?: boolean;
+}
+
+export interface SavedLocationsResponse {
+  success: boolean;
+  locations: SavedLocation[];
+}
+
+export interface SavedLocationResponse {
+  success: boolean;
+  location: SavedLocation;
+}
@@ -1,26 +1,47 @@
 // Weather-specific types and interfaces
 
 export interface WeatherRequest {
-  latitude: number;
-  longitude: number;
+  latitude?: number;
+  longitude?: number;
+  query?: string; // City name or ZIP code (e.g. "London", "94040,us"), used when coordinates are omitted
+  locationId?: string; // Saved location ID, resolved to coordinates for the signed-in user
   units?: "metric" | "imperial";
+  dual?: boolean; // Also return temperature and wind in the other unit system
+  view?: WeatherView; // "minimal" returns MinimalWeatherData; defaults to "full"
+  refresh?: boolean; // Skip the cache read and fetch from the provider (the result is still cached)
 }
 
 export interface ForecastRequest {
   latitude: number;
   longitude: number;
   units?: "metric" | "imperial";
+  includeTrend?: boolean; // Compare each day against current conditions (extra current-weather lookup)
+  date?: string; // YYYY-MM-DD - return only that day, which must be within the forecast window
+  granularity?: ForecastGranularity; // Defaults to "daily"
+  locationId?: string; // Saved location ID, resolved to coordinates for the signed-in user
+  dual?: boolean; // Also return temperatures and wind in the other unit system
+  days?: number; // How many days to return, up to the configured plan's limit; defaults to all of them
+  refresh?: boolean; // Skip the cache read and fetch from the provider (the result is still cached)
 }
 
 export interface WeatherData {
   temperature: number;
   condition: string;
+  conditionCode: number | null; // Provider condition code, stable across languages
+  icon: string;
   humidity: number;
   windSpeed: number;
   windDirection: string;
   pressure: number;
+  uvIndex: number | null; // null in 2.5 mode, which has no UV source, or when One Call omits it
   location: string;
   timestamp: string;
+  alerts?: WeatherAlert[]; // Government weather alerts - only in One Call 3.0 mode, where they come with the reading
+  alternateUnits?: { // Only with dual
+    units: "metric" | "imperial";
+    temperature: number;
+    windSpeed: number;
+  };
 }
 
 export interface ForecastDay {
@@ -28,6 +49,8 @@ export interface ForecastDay {
   dayName: string;
   highTemp: number;
   lowTemp: number;
+  feelsLikeHigh: number; // Provider's apparent temperature, aggregated like highTemp/lowTemp
+  feelsLikeLow: number;
   condition: string;
   icon: string;
   humidity: number;
@@ -35,30 +58,145 @@ export interface ForecastDay {
   windDirection: string;
   pressure: number;
   precipitation: number;
+  tempDelta?: number; // Day's mean temperature minus the current temperature (only with includeTrend)
+  alternateUnits?: { // Only with dual
+    units: "metric" | "imperial";
+    highTemp: number;
+    lowTemp: number;
+    windSpeed: number;
+  };
+}
+
+// A forecast entry as returned by the provider (3-hourly, or hourly on One Call 3.0), without the day-grouping collapse
+export interface ForecastHour {
+  time: string; // ISO 8601, UTC
+  temperature: number;
+  condition: string;
+  icon: string;
+  precipitation: number;
 }
 
+export type ForecastGranularity = "hourly" | "daily" | "both";
+
+export type TemperatureTrend = "warming" | "cooling" | "steady";
+
 export interface ForecastData {
   location: string;
-  days: ForecastDay[];
+  days: ForecastDay[]; // Empty for granularity "hourly"
+  hourly?: ForecastHour[]; // Next 48 hours, only for granularity "hourly" or "both"
+  trend?: TemperatureTrend;
+}
+
+// Reasons a response can be degraded, joined with ", " in degradedReason
+export type DegradedReason = "uv_unavailable" | "trend_unavailable";
+
+export type WeatherView = "full" | "minimal";
+
+// Versioned compact payload for watches and embedded clients - fields are only ever added under a new version
+export interface MinimalWeatherData {
+  version: 1;
+  temperature: number;
+  conditionCode: number | null;
+  icon: string;
+}
+
+export interface MinimalWeatherResponse {
+  success: boolean;
+  data: MinimalWeatherData;
+  cached: boolean;
+  degraded: boolean;
 }
 
 export interface WeatherResponse {
   success: boolean;
   data: WeatherData;
   cached: boolean;
+  degraded: boolean; // Some of the data is missing or less complete than usual
+  degradedReason: string | null;
+  locationInferred?: boolean; // The request had no location, so the deployment fallback was used
   error?: string;
 }
 
+export interface WeatherBatchRequest {
+  locations: WeatherRequest[]; // Each item takes coordinates, a query or a locationId; units applies to the whole batch
+  units?: "metric" | "imperial";
+}
+
+export interface WeatherBatchItem {
+  success: boolean;
+  data?: WeatherData;
+  cached?: boolean;
+  degraded?: boolean;
+  degradedReason?: string | null;
+  error?: string; // Set instead of data when this location failed; the rest of the batch is unaffected
+}
+
+export interface WeatherBatchResponse {
+  success: boolean;
+  results: WeatherBatchItem[]; // Same order as the request's locations
+}
+
 export interface ForecastResponse {
   success: boolean;
   data: ForecastData;
   cached: boolean;
+  degraded: boolean; // Some of the data is missing or less complete than usual
+  degradedReason: string | null;
+  locationInferred?: boolean; // The request had no location, so the deployment fallback was used
+  error?: string;
+}
+
+export interface WeatherAlert {
+  event: string;
+  sender: string;
+  start: string; // ISO 8601
+  end: string; // ISO 8601
+  description: string;
+}
+
+// Air quality from OpenWeatherMap's air pollution API; concentrations are in μg/m³
+export interface AirQualityData {
+  aqi: number; // 1 (Good) to 5 (Very Poor)
+  category: AirQualityCategory;
+  pm2_5: number;
+  pm10: number;
+  o3: number;
+  no2: number;
+  co: number;
+  so2: number;
+  timestamp: string;
+}
+
+export type AirQualityCategory = "Good" | "Fair" | "Moderate" | "Poor" | "Very Poor";
+
+export interface AirQualityResponse {
+  success: boolean;
+  data: AirQualityData;
+  cached: boolean;
+  locationInferred?: boolean; // The request had no location, so the deployment fallback was used
+  error?: string;
+}
+
+export interface WeatherSummary {
+  summary: string;
+  location: string;
+  timestamp: string;
+}
+
+export interface WeatherSummaryResponse {
+  success: boolean;
+  data: WeatherSummary;
+  cached: boolean;
+  degraded: boolean; // Some of the data is missing or less complete than usual
+  degradedReason: string | null;
+  locationInferred?: boolean; // The request had no location, so the deployment fallback was used
   error?: string;
 }
 
 // OpenWeatherMap API response types
 export interface OpenWeatherMain {
   temp: number;
+  feels_like?: number; // Absent on some partial responses; temp stands in
   temp_min: number;
   temp_max: number;
   humidity: number;
@@ -66,6 +204,7 @@ export interface OpenWeatherMain {
 }
 
 export interface OpenWeatherWeather {
+  id?: number; // Condition code (e.g. 800 = clear), https://openweathermap.org/weather-conditions
   description: string;
   icon: string;
 }
@@ -85,6 +224,57 @@ export interface OpenWeatherCurrentResponse {
   };
 }
 
+// One Call 3.0 - current, hourly (48h), daily (8 days) and alerts in one response
+export interface OpenWeatherOneCallHour {
+  dt: number;
+  temp: number;
+  feels_like: number;
+  pressure: number;
+  humidity: number;
+  wind_speed: number;
+  wind_deg: number;
+  weather: OpenWeatherWeather[];
+  pop?: number;
+}
+
+export interface OpenWeatherOneCallDay {
+  dt: number;
+  temp: { min: number; max: number };
+  feels_like: { day: number; night: number; eve: number; morn: number };
+  pressure: number;
+  humidity: number;
+  wind_speed: number;
+  wind_deg: number;
+  weather: OpenWeatherWeather[];
+  pop?: number;
+}
+
+export interface OpenWeatherOneCallResponse {
+  timezone_offset: number; // Seconds from UTC
+  current: {
+    dt: number;
+    temp: number;
+    feels_like: number;
+    pressure: number;
+    humidity: number;
+    uvi: number;
+    wind_speed: number;
+    wind_deg: number;
+    weather: OpenWeatherWeather[];
+  };
+  hourly?: OpenWeatherOneCallHour[];
+  daily?: OpenWeatherOneCallDay[];
+  alerts?: Array<{ sender_name: string; event: string; start: number; end: number; description: string }>;
+}
+
+export interface OpenWeatherAirPollutionResponse {
+  list: Array<{
+    dt: number;
+    main: { aqi: number };
+    components: { co: number; no2: number; o3: number; so2: number; pm2_5: number; pm10: number };
+  }>;
+}
+
 export interface OpenWeatherForecastItem {
   dt: number;
   main: OpenWeatherMain;